@@ -0,0 +1,466 @@
+package acl
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"tempDB/engine"
+	"tempDB/utils"
+	"time"
+)
+
+// maxLogEntries bounds the log of denied attempts, oldest entries going first
+const maxLogEntries = 128
+
+// User is a named account with the commands, keys and channels it may use.
+// Users are never modified in place: SetUser replaces them, so a User
+// returned by the ACL can be read without holding any lock.
+type User struct {
+	Name      string
+	Enabled   bool
+	NoPass    bool     // any password authenticates
+	Passwords []string // hex encoded SHA-256 of each accepted password
+	Commands  []string // +cmd, -cmd, +@category, -@category, applied in order
+	Keys      []string // glob patterns of the keys it may access
+	Channels  []string // glob patterns of the channels it may publish and subscribe to
+}
+
+// newUser creates a user that can do nothing, as redis does
+func newUser(name string) *User {
+	return &User{Name: name}
+}
+
+func (u *User) clone() *User {
+	copied := *u
+	copied.Passwords = append([]string(nil), u.Passwords...)
+	copied.Commands = append([]string(nil), u.Commands...)
+	copied.Keys = append([]string(nil), u.Keys...)
+	copied.Channels = append([]string(nil), u.Channels...)
+	return &copied
+}
+
+// HashPassword returns the hex encoded SHA-256 of a password, the form
+// passwords are stored in
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// validHash reports whether s is a hex encoded SHA-256
+func validHash(s string) bool {
+	hash, err := hex.DecodeString(s)
+	return err == nil && len(hash) == sha256.Size
+}
+
+// checkPassword reports whether password is one of the user's, comparing
+// the hashes in constant time so the reply timing tells nothing about them
+func (u *User) checkPassword(password string) bool {
+	if u.NoPass {
+		return true
+	}
+	sum := sha256.Sum256([]byte(password))
+	matched := 0
+	for _, stored := range u.Passwords {
+		hash, _ := hex.DecodeString(stored)
+		matched |= subtle.ConstantTimeCompare(hash, sum[:])
+	}
+	return matched == 1
+}
+
+// apply applies one redis style ACL rule to the user
+func (u *User) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.Enabled = true
+	case "off":
+		u.Enabled = false
+	case "nopass":
+		u.NoPass, u.Passwords = true, nil
+	case "resetpass":
+		u.NoPass, u.Passwords = false, nil
+	case "allcommands":
+		u.Commands = []string{"+@all"}
+	case "nocommands":
+		u.Commands = []string{"-@all"}
+	case "allkeys":
+		u.Keys = []string{"*"}
+	case "resetkeys":
+		u.Keys = nil
+	case "allchannels":
+		u.Channels = []string{"*"}
+	case "resetchannels":
+		u.Channels = nil
+	case "reset":
+		*u = *newUser(u.Name)
+	default:
+		return u.applyArgument(rule)
+	}
+	return nil
+}
+
+// applyArgument applies a rule carrying an argument after its first character
+func (u *User) applyArgument(rule string) error {
+	if len(rule) < 2 {
+		return fmt.Errorf("Syntax error")
+	}
+	arg := rule[1:]
+	switch rule[0] {
+	case '>':
+		u.addPassword(HashPassword(arg))
+	case '<':
+		u.removePassword(HashPassword(arg))
+	case '#':
+		if !validHash(arg) {
+			return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.addPassword(strings.ToLower(arg))
+	case '!':
+		u.removePassword(strings.ToLower(arg))
+	case '~':
+		u.Keys = append(u.Keys, arg)
+	case '&':
+		u.Channels = append(u.Channels, arg)
+	case '+', '-':
+		if err := checkCommandRule(arg); err != nil {
+			return err
+		}
+		u.Commands = append(u.Commands, strings.ToLower(rule))
+	default:
+		return fmt.Errorf("Syntax error")
+	}
+	return nil
+}
+
+// checkCommandRule checks that a command rule names a known command or category
+func checkCommandRule(arg string) error {
+	if category, isCategory := strings.CutPrefix(strings.ToLower(arg), "@"); isCategory {
+		if category != "all" && len(engine.CommandsIn(category)) == 0 {
+			return fmt.Errorf("Unknown command or category name in ACL")
+		}
+		return nil
+	}
+	if _, exists := engine.LookupCommand(arg); !exists {
+		return fmt.Errorf("Unknown command or category name in ACL")
+	}
+	return nil
+}
+
+func (u *User) addPassword(hash string) {
+	u.NoPass = false
+	for _, stored := range u.Passwords {
+		if stored == hash {
+			return
+		}
+	}
+	u.Passwords = append(u.Passwords, hash)
+}
+
+func (u *User) removePassword(hash string) {
+	kept := u.Passwords[:0]
+	for _, stored := range u.Passwords {
+		if stored != hash {
+			kept = append(kept, stored)
+		}
+	}
+	u.Passwords = kept
+}
+
+// Rules describes the user as the rules recreating it, as ACL LIST shows them
+func (u *User) Rules() string {
+	rules := []string{"off"}
+	if u.Enabled {
+		rules[0] = "on"
+	}
+	if u.NoPass {
+		rules = append(rules, "nopass")
+	}
+	for _, hash := range u.Passwords {
+		rules = append(rules, "#"+hash)
+	}
+	if len(u.Keys) == 0 {
+		rules = append(rules, "resetkeys")
+	}
+	for _, pattern := range u.Keys {
+		rules = append(rules, "~"+pattern)
+	}
+	if len(u.Channels) == 0 {
+		rules = append(rules, "resetchannels")
+	}
+	for _, pattern := range u.Channels {
+		rules = append(rules, "&"+pattern)
+	}
+	if len(u.Commands) == 0 {
+		rules = append(rules, "-@all")
+	}
+	rules = append(rules, u.Commands...)
+	return strings.Join(rules, " ")
+}
+
+// CanRun reports whether the user may run the command, which belongs to the
+// given categories. The last rule matching the command wins.
+func (u *User) CanRun(name string, categories []string) bool {
+	name = strings.ToLower(name)
+	allowed := false
+	for _, rule := range u.Commands {
+		target := rule[1:]
+		matches := target == name || target == "@all"
+		if category, isCategory := strings.CutPrefix(target, "@"); isCategory {
+			for _, c := range categories {
+				matches = matches || c == category
+			}
+		}
+		if matches {
+			allowed = rule[0] == '+'
+		}
+	}
+	return allowed
+}
+
+// CanAccessKey reports whether the user may access the key
+func (u *User) CanAccessKey(key string) bool {
+	return matchAny(u.Keys, key)
+}
+
+// CanAccessChannel reports whether the user may publish or subscribe to the channel
+func (u *User) CanAccessChannel(channel string) bool {
+	return matchAny(u.Channels, channel)
+}
+
+// CanAccessPattern reports whether the user may subscribe to a channel
+// pattern. A pattern could match any channel, so it must be one of the
+// user's own patterns, unless the user may access every channel.
+func (u *User) CanAccessPattern(pattern string) bool {
+	for _, allowed := range u.Channels {
+		if allowed == "*" || allowed == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if utils.GlobMatch(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// LogEntry records denied attempts; identical attempts share an entry
+type LogEntry struct {
+	Count    int
+	Reason   string // auth, command, key or channel
+	Context  string // toplevel, multi or script
+	Object   string // the command, key or channel that was denied
+	Username string
+	Client   string // address of the client
+	Time     time.Time
+}
+
+// ACL holds the users and the log of denied attempts
+type ACL struct {
+	mutex *sync.RWMutex
+	users map[string]*User
+	log   []*LogEntry // newest first
+}
+
+// New creates an ACL with only the default user, which can do anything
+// without a password, as on a server without any ACL configuration
+func New() *ACL {
+	a := &ACL{
+		mutex: &sync.RWMutex{},
+		users: make(map[string]*User),
+	}
+	a.users["default"] = &User{
+		Name:     "default",
+		Enabled:  true,
+		NoPass:   true,
+		Commands: []string{"+@all"},
+		Keys:     []string{"*"},
+		Channels: []string{"*"},
+	}
+	return a
+}
+
+// SetUser creates the user or modifies it with the rules, applied in order.
+// Nothing changes if a rule is invalid.
+func (a *ACL) SetUser(name string, rules []string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.setUser(a.users, name, rules)
+}
+
+func (a *ACL) setUser(users map[string]*User, name string, rules []string) error {
+	user := newUser(name)
+	if existing, exists := users[name]; exists {
+		user = existing.clone()
+	}
+	for _, rule := range rules {
+		if err := user.apply(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", rule, err)
+		}
+	}
+	users[name] = user
+	return nil
+}
+
+// User returns the user with the given name
+func (a *ACL) User(name string) (*User, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	user, exists := a.users[name]
+	return user, exists
+}
+
+// DelUser deletes users and returns how many existed. The default user can
+// not be deleted.
+func (a *ACL) DelUser(names []string) (int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, name := range names {
+		if name == "default" {
+			return 0, fmt.Errorf("The 'default' user cannot be removed")
+		}
+	}
+	deleted := 0
+	for _, name := range names {
+		if _, exists := a.users[name]; exists {
+			delete(a.users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Users returns every user sorted by name
+func (a *ACL) Users() []*User {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	users := make([]*User, 0, len(a.users))
+	for _, user := range a.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// Authenticate returns the user if it is enabled and the password is one of its own
+func (a *ACL) Authenticate(name, password string) (*User, bool) {
+	user, exists := a.User(name)
+	if !exists || !user.Enabled || !user.checkPassword(password) {
+		return nil, false
+	}
+	return user, true
+}
+
+// LogDenied records a denied attempt, merging it with an identical earlier one
+func (a *ACL) LogDenied(entry LogEntry) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entry.Time = time.Now()
+	for i, logged := range a.log {
+		if logged.Reason == entry.Reason && logged.Context == entry.Context && logged.Object == entry.Object &&
+			logged.Username == entry.Username && logged.Client == entry.Client {
+			logged.Count++
+			logged.Time = entry.Time
+			//move it back to the front, it is the newest again
+			copy(a.log[1:i+1], a.log[:i])
+			a.log[0] = logged
+			return
+		}
+	}
+
+	entry.Count = 1
+	a.log = append([]*LogEntry{&entry}, a.log...)
+	if len(a.log) > maxLogEntries {
+		a.log = a.log[:maxLogEntries]
+	}
+}
+
+// Log returns up to count of the newest log entries, all of them if count is below 1
+func (a *ACL) Log(count int) []LogEntry {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if count < 1 || count > len(a.log) {
+		count = len(a.log)
+	}
+	entries := make([]LogEntry, count)
+	for i := range entries {
+		entries[i] = *a.log[i]
+	}
+	return entries
+}
+
+// ResetLog clears the log of denied attempts
+func (a *ACL) ResetLog() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.log = nil
+}
+
+// LoadFile replaces the users with those of an ACL file, one per line in
+// the ACL LIST format ("user <name> <rules...>"), with blank lines and lines
+// starting with '#' ignored. The users are left untouched if the file has an
+// error. A file without a default user keeps the current one.
+func (a *ACL) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: lines must start with 'user <name>'", path, line)
+		}
+		if _, exists := users[fields[1]]; exists {
+			return fmt.Errorf("%s:%d: duplicate user '%s'", path, line, fields[1])
+		}
+		if err := a.setUser(users, fields[1], fields[2:]); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if _, exists := users["default"]; !exists {
+		users["default"] = a.users["default"]
+	}
+	a.users = users
+	return nil
+}
+
+// SaveFile writes the users to an ACL file, replacing it atomically
+func (a *ACL) SaveFile(path string) error {
+	var content strings.Builder
+	for _, user := range a.Users() {
+		fmt.Fprintf(&content, "user %s %s\n", user.Name, user.Rules())
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
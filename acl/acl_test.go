@@ -0,0 +1,254 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"tempDB/engine"
+	"testing"
+)
+
+// newTestUser creates the user "u" with the rules
+func newTestUser(t *testing.T, rules ...string) *User {
+	t.Helper()
+	a := New()
+	if err := a.SetUser("u", rules); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := a.User("u")
+	return user
+}
+
+func TestKeyAndChannelPatterns(t *testing.T) {
+	user := newTestUser(t, "~user:*", "~cache:?", "~[ab]x", "&news.*", "&alerts")
+	tests := []struct {
+		name string
+		can  func(string) bool
+		arg  string
+		want bool
+	}{
+		{"key prefix", user.CanAccessKey, "user:1", true},
+		{"key prefix alone", user.CanAccessKey, "user:", true},
+		{"key other prefix", user.CanAccessKey, "users:1", false},
+		{"key single character", user.CanAccessKey, "cache:a", true},
+		{"key two characters", user.CanAccessKey, "cache:ab", false},
+		{"key class", user.CanAccessKey, "bx", true},
+		{"key out of class", user.CanAccessKey, "cx", false},
+		{"channel prefix", user.CanAccessChannel, "news.sport", true},
+		{"channel exact", user.CanAccessChannel, "alerts", true},
+		{"channel other", user.CanAccessChannel, "alerts.high", false},
+		{"pattern of the user", user.CanAccessPattern, "news.*", true},
+		{"pattern matching fewer channels", user.CanAccessPattern, "news.s*", false},
+		{"pattern of any channel", user.CanAccessPattern, "*", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.can(tt.arg); got != tt.want {
+				t.Errorf("access to %q = %v, want %v", tt.arg, got, tt.want)
+			}
+		})
+	}
+
+	if !newTestUser(t, "allkeys").CanAccessKey("anything") {
+		t.Error("allkeys can not access a key")
+	}
+	if !newTestUser(t, "allchannels").CanAccessPattern("news.s*") {
+		t.Error("allchannels can not subscribe to a pattern")
+	}
+	if newTestUser(t, "~*", "resetkeys").CanAccessKey("anything") {
+		t.Error("resetkeys can still access a key")
+	}
+}
+
+func TestCommandRules(t *testing.T) {
+	tests := []struct {
+		rules   []string
+		command string
+		want    bool
+	}{
+		{nil, "GET", false},
+		{[]string{"+@all"}, "FLUSHDB", true},
+		{[]string{"allcommands"}, "GET", true},
+		{[]string{"+@read"}, "GET", true},
+		{[]string{"+@read"}, "SET", false},
+		{[]string{"+@string"}, "SET", true},
+		{[]string{"+@all", "-@dangerous"}, "FLUSHDB", false},
+		{[]string{"+@all", "-@dangerous"}, "GET", true},
+		{[]string{"-@all", "+get"}, "GET", true},
+		{[]string{"-@all", "+get"}, "SET", false},
+		{[]string{"+@write", "-set"}, "SET", false},
+		{[]string{"+@write", "-set"}, "DEL", true},
+		{[]string{"-set", "+@write"}, "SET", true},
+		{[]string{"+@read", "nocommands"}, "GET", false},
+		{[]string{"+GET"}, "get", true},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.rules, " ")+" "+tt.command, func(t *testing.T) {
+			info, exists := engine.LookupCommand(strings.ToUpper(tt.command))
+			if !exists {
+				t.Fatalf("unknown command %s", tt.command)
+			}
+			user := newTestUser(t, tt.rules...)
+			if got := user.CanRun(tt.command, info.Categories()); got != tt.want {
+				t.Errorf("CanRun(%s) = %v, want %v", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	tests := []string{
+		"+nosuchcommand",
+		"-@nosuchcategory",
+		"#abc",
+		"#" + strings.Repeat("g", 64),
+		"x",
+		"?pattern",
+	}
+	for _, rule := range tests {
+		t.Run(rule, func(t *testing.T) {
+			a := New()
+			if err := a.SetUser("u", []string{"on", rule}); err == nil {
+				t.Errorf("SETUSER u on %s succeeded", rule)
+			}
+			if _, exists := a.User("u"); exists {
+				t.Error("the user was created despite the invalid rule")
+			}
+		})
+	}
+}
+
+func TestPasswords(t *testing.T) {
+	hash := HashPassword("secret")
+	tests := []struct {
+		name     string
+		rules    []string
+		password string
+		want     bool
+	}{
+		{"hash", []string{"on", "#" + hash}, "secret", true},
+		{"hash, wrong password", []string{"on", "#" + hash}, "other", false},
+		{"hash in upper case", []string{"on", "#" + strings.ToUpper(hash)}, "secret", true},
+		{"plain password", []string{"on", ">secret"}, "secret", true},
+		{"one of two", []string{"on", ">other", "#" + hash}, "secret", true},
+		{"hash removed", []string{"on", "#" + hash, "!" + hash}, "secret", false},
+		{"password removed by hash", []string{"on", ">secret", "!" + hash}, "secret", false},
+		{"hash removed by password", []string{"on", "#" + hash, "<secret"}, "secret", false},
+		{"nopass", []string{"on", "nopass"}, "anything", true},
+		{"password after nopass", []string{"on", "nopass", ">secret"}, "anything", false},
+		{"resetpass", []string{"on", ">secret", "resetpass"}, "secret", false},
+		{"disabled", []string{"off", "#" + hash}, "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := New()
+			if err := a.SetUser("u", tt.rules); err != nil {
+				t.Fatal(err)
+			}
+			if _, got := a.Authenticate("u", tt.password); got != tt.want {
+				t.Errorf("Authenticate(u, %q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+
+	//only the hash is ever stored
+	user := newTestUser(t, ">secret")
+	if strings.Contains(user.Rules(), "secret") || !strings.Contains(user.Rules(), "#"+hash) {
+		t.Errorf("Rules() = %q, want the hash of the password only", user.Rules())
+	}
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	users := map[string][]string{
+		"default": {"on", "#" + HashPassword("admin"), "allkeys", "allchannels", "+@all"},
+		"reader":  {"on", ">pass", "~user:*", "~cache:?", "&news.*", "-@all", "+@read", "-scan"},
+		"writer":  {"on", "nopass", "allkeys", "resetchannels", "+@write", "-@dangerous"},
+		"off":     {"off", "#" + HashPassword("a"), "#" + HashPassword("b")},
+	}
+	saved := New()
+	for name, rules := range users {
+		if err := saved.SetUser(name, rules); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "users.acl")
+	if err := saved.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New()
+	if err := loaded.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(loaded.Users()) != len(users) {
+		t.Fatalf("loaded %d users, want %d", len(loaded.Users()), len(users))
+	}
+	for _, want := range saved.Users() {
+		got, exists := loaded.User(want.Name)
+		if !exists {
+			t.Errorf("user %s was not loaded", want.Name)
+			continue
+		}
+		if got.Rules() != want.Rules() {
+			t.Errorf("user %s loaded as %q, want %q", want.Name, got.Rules(), want.Rules())
+		}
+	}
+	if _, ok := loaded.Authenticate("reader", "pass"); !ok {
+		t.Error("reader can not authenticate after the round trip")
+	}
+	if _, ok := loaded.Authenticate("default", "admin"); !ok {
+		t.Error("default can not authenticate after the round trip")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string // empty when the file loads
+		users   []string
+	}{
+		{"comments and blank lines", "# users\n\nuser a on nopass\n", "", []string{"a", "default"}},
+		{"default kept", "user a on\n", "", []string{"a", "default"}},
+		{"no user keyword", "a on nopass\n", ":1: lines must start with 'user <name>'", nil},
+		{"no name", "user\n", ":1: lines must start with 'user <name>'", nil},
+		{"duplicate user", "user a on\nuser a off\n", ":2: duplicate user 'a'", nil},
+		{"invalid rule", "user a on\nuser b +nosuchcommand\n", ":2: Error in ACL SETUSER modifier '+nosuchcommand'", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.acl")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			a := New()
+			if err := a.SetUser("existing", []string{"on"}); err != nil {
+				t.Fatal(err)
+			}
+			err := a.LoadFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("LoadFile = %v, want an error with %q", err, tt.wantErr)
+				}
+				//a file with an error leaves the users untouched
+				if _, exists := a.User("existing"); !exists {
+					t.Error("the users were replaced despite the error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			names := []string{}
+			for _, user := range a.Users() {
+				names = append(names, user.Name)
+			}
+			if strings.Join(names, " ") != strings.Join(tt.users, " ") {
+				t.Errorf("users = %v, want %v", names, tt.users)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net"
 	"os"
 	"path/filepath"
 	"sync"
@@ -19,16 +20,91 @@ type StoreConfig struct {
 	WALMaxSizeBytes         int64  `yaml:"wal_max_size_bytes"`
 	WALMaxFiles             int    `yaml:"wal_max_files"`
 	WALDirectory            string `yaml:"wal_directory"`
+	NotifyKeyspaceEvents    string `yaml:"notify_keyspace_events"`
+	LuaTimeLimitMs          int64  `yaml:"lua_time_limit_ms"` // scripts running longer are stopped, negative never stops them
 }
 
 type ServerConfig struct {
-	Port string `yaml:"port"`
-	Host string `yaml:"host"`
+	Port        string    `yaml:"port"`
+	Host        string    `yaml:"host"`
+	RequirePass string    `yaml:"requirepass"` // hex encoded SHA-256 of the password, empty for none
+	ACLFile     string    `yaml:"acl_file"`    // users loaded at startup, empty for none
+	TLS         TLSConfig `yaml:"tls"`
+
+	//listeners accepting clients, host:port alone when empty
+	Listeners []ListenerConfig `yaml:"listeners"`
+
+	//connection limits
+	MaxClients              int                     `yaml:"maxclients"`
+	IdleTimeoutSeconds      int                     `yaml:"idle_timeout_seconds"`  // 0 never closes idle clients
+	TCPKeepAliveSeconds     int                     `yaml:"tcp_keepalive_seconds"` // negative disables keepalives
+	ClientOutputBufferLimit ClientOutputBufferLimit `yaml:"client_output_buffer_limit"`
+
+	//Prometheus metrics served over HTTP at /metrics, host:port, empty disables it
+	MetricsAddress string `yaml:"metrics_address"`
+
+	//commands running for longer than the threshold are kept for SLOWLOG
+	SlowlogLogSlowerThan *int64 `yaml:"slowlog_log_slower_than"` // microseconds, 0 records every command, negative disables the slow log
+	SlowlogMaxLen        int    `yaml:"slowlog_max_len"`
+}
+
+// ClientOutputBufferLimit bounds the replies waiting to be sent to clients,
+// for ordinary clients and for clients that have subscribed to channels
+type ClientOutputBufferLimit struct {
+	Normal OutputBufferLimit `yaml:"normal"`
+	PubSub OutputBufferLimit `yaml:"pubsub"`
+}
+
+// OutputBufferLimit disconnects a client whose pending output reaches
+// HardBytes, or stays above SoftBytes for SoftSeconds. 0 disables a limit.
+type OutputBufferLimit struct {
+	HardBytes   int `yaml:"hard_bytes"`
+	SoftBytes   int `yaml:"soft_bytes"`
+	SoftSeconds int `yaml:"soft_seconds"`
+}
+
+// ListenerConfig is an address clients connect to
+type ListenerConfig struct {
+	Network     string `yaml:"network"`     // tcp or unix
+	Address     string `yaml:"address"`     // host:port, or the socket path
+	Permissions string `yaml:"permissions"` // octal mode of the socket file, e.g. "0770"
+	TLS         bool   `yaml:"tls"`         // TCP only, using the tls section
+}
+
+// TLSConfig enables TLS on client connections when CertFile is set
+type TLSConfig struct {
+	CertFile              string   `yaml:"cert_file"`
+	KeyFile               string   `yaml:"key_file"`
+	CAFile                string   `yaml:"ca_file"`     // CAs client certificates are verified against
+	MinVersion            string   `yaml:"min_version"` // 1.0, 1.1, 1.2 or 1.3
+	CipherSuites          []string `yaml:"cipher_suites"`
+	ClientAuth            string   `yaml:"client_auth"`             // none, optional or required
+	ReloadIntervalSeconds int      `yaml:"reload_interval_seconds"` // negative never reloads the files
+}
+
+type PubSubConfig struct {
+	MaxPendingMessages int `yaml:"max_pending_messages"`
+}
+
+type QueueConfig struct {
+	VisibilityTimeoutMs int64 `yaml:"visibility_timeout_ms"`
+	MaxAttempts         int64 `yaml:"max_attempts"`
+}
+
+// LogConfig sets up the structured logger of the server
+type LogConfig struct {
+	Level     string `yaml:"level"`      // debug, info, warn or error
+	Format    string `yaml:"format"`     // text or json
+	File      string `yaml:"file"`       // appended to, stdout when empty
+	LogValues bool   `yaml:"log_values"` // log command arguments and replies, which are redacted otherwise
 }
 
 type Config struct {
 	Store  StoreConfig  `yaml:"store"`
 	Server ServerConfig `yaml:"server"`
+	PubSub PubSubConfig `yaml:"pubsub"`
+	Queue  QueueConfig  `yaml:"queue"`
+	Log    LogConfig    `yaml:"log"`
 }
 
 var (
@@ -69,6 +145,21 @@ func GetServerConfig() *ServerConfig {
 	return &GetConfig().Server
 }
 
+// GetLogConfig returns only the logging configuration
+func GetLogConfig() *LogConfig {
+	return &GetConfig().Log
+}
+
+// GetPubSubConfig returns only the pub/sub configuration
+func GetPubSubConfig() *PubSubConfig {
+	return &GetConfig().PubSub
+}
+
+// GetQueueConfig returns only the work queue configuration
+func GetQueueConfig() *QueueConfig {
+	return &GetConfig().Queue
+}
+
 func loadConfig(path string) (*Config, error) {
 	config := &Config{}
 
@@ -110,12 +201,67 @@ func loadConfig(path string) (*Config, error) {
 	if config.Store.WALDirectory == "" {
 		config.Store.WALDirectory = filepath.Dir(config.Store.WALFilePath)
 	}
+	if config.Store.LuaTimeLimitMs == 0 {
+		config.Store.LuaTimeLimitMs = 5000
+	}
 	if config.Server.Port == "" {
 		config.Server.Port = "8090"
 	}
 	if config.Server.Host == "" {
 		config.Server.Host = "localhost"
 	}
+	if len(config.Server.Listeners) == 0 {
+		config.Server.Listeners = []ListenerConfig{{
+			Network: "tcp",
+			Address: net.JoinHostPort(config.Server.Host, config.Server.Port),
+			TLS:     config.Server.TLS.CertFile != "",
+		}}
+	}
+	for i := range config.Server.Listeners {
+		if config.Server.Listeners[i].Network == "" {
+			config.Server.Listeners[i].Network = "tcp"
+		}
+	}
+	if config.Server.MaxClients == 0 {
+		config.Server.MaxClients = 10000
+	}
+	if config.Server.TCPKeepAliveSeconds == 0 {
+		config.Server.TCPKeepAliveSeconds = 300
+	}
+	if config.Server.ClientOutputBufferLimit.PubSub == (OutputBufferLimit{}) {
+		config.Server.ClientOutputBufferLimit.PubSub = OutputBufferLimit{HardBytes: 32 << 20, SoftBytes: 8 << 20, SoftSeconds: 60}
+	}
+	if config.Server.SlowlogLogSlowerThan == nil {
+		threshold := int64(10000) // 10ms, 0 is kept as it records every command
+		config.Server.SlowlogLogSlowerThan = &threshold
+	}
+	if config.Server.SlowlogMaxLen == 0 {
+		config.Server.SlowlogMaxLen = 128
+	}
+	if config.Server.TLS.MinVersion == "" {
+		config.Server.TLS.MinVersion = "1.2"
+	}
+	if config.Server.TLS.ClientAuth == "" {
+		config.Server.TLS.ClientAuth = "none"
+	}
+	if config.Server.TLS.ReloadIntervalSeconds == 0 {
+		config.Server.TLS.ReloadIntervalSeconds = 60 // how often the certificate files are checked for changes
+	}
+	if config.Log.Level == "" {
+		config.Log.Level = "info"
+	}
+	if config.Log.Format == "" {
+		config.Log.Format = "text"
+	}
+	if config.PubSub.MaxPendingMessages == 0 {
+		config.PubSub.MaxPendingMessages = 1024 // messages queued per subscriber before it is dropped
+	}
+	if config.Queue.VisibilityTimeoutMs == 0 {
+		config.Queue.VisibilityTimeoutMs = 30000 // lease length when QPOP gives none
+	}
+	if config.Queue.MaxAttempts == 0 {
+		config.Queue.MaxAttempts = 5 // deliveries before a message is dead-lettered
+	}
 
 	return config, nil
 }
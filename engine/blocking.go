@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// blockedError is returned by a blocking command that found nothing to
+// reply with. CommandHandler then waits for a write to one of the keys and
+// runs the command again with retry as its parameters.
+type blockedError struct {
+	keys    []string
+	timeout time.Duration // 0 waits until woken
+	retry   []string      // the parameters with anything relative ("$") resolved
+}
+
+func (e *blockedError) Error() string {
+	return "-ERR command blocked\r\n"
+}
+
+// waitList wakes blocked commands when the keys they wait on are written
+type waitList struct {
+	mutex *sync.Mutex
+	keys  map[string]map[chan struct{}]bool
+}
+
+func newWaitList() *waitList {
+	return &waitList{
+		mutex: &sync.Mutex{},
+		keys:  make(map[string]map[chan struct{}]bool),
+	}
+}
+
+// add registers a waiter for the keys and returns the channel it is woken on
+func (w *waitList) add(keys []string) chan struct{} {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	wake := make(chan struct{}, 1)
+	for _, key := range keys {
+		if w.keys[key] == nil {
+			w.keys[key] = make(map[chan struct{}]bool)
+		}
+		w.keys[key][wake] = true
+	}
+	return wake
+}
+
+// remove unregisters a waiter added for the keys
+func (w *waitList) remove(keys []string, wake chan struct{}) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(w.keys[key], wake)
+		if len(w.keys[key]) == 0 {
+			delete(w.keys, key)
+		}
+	}
+}
+
+// signal wakes every waiter of key
+func (w *waitList) signal(key string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for wake := range w.keys[key] {
+		select {
+		case wake <- struct{}{}:
+		default: //already woken
+		}
+	}
+}
+
+// wait blocks until woken, until the deadline (a zero deadline never
+// passes) or until gone is closed, reporting whether it was woken
+func (w *waitList) wait(wake chan struct{}, deadline time.Time, gone <-chan struct{}) bool {
+	if deadline.IsZero() {
+		select {
+		case <-wake:
+			return true
+		case <-gone:
+			return false
+		}
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-wake:
+		return true
+	case <-timer.C:
+		return false
+	case <-gone:
+		return false
+	}
+}
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"tempDB/utils"
+	"testing"
+	"time"
+)
+
+func TestBlockedClientGone(t *testing.T) {
+	db := newTestStore(t)
+
+	gone := make(chan struct{})
+	returned := make(chan []byte)
+	go func() {
+		response, _, _ := db.CommandHandler(utils.Request{Command: "QPOP", Params: []string{"q", "BLOCK", "0"}}, nil, gone)
+		returned <- response
+	}()
+
+	//let the QPOP block, then take its client away
+	time.Sleep(50 * time.Millisecond)
+	close(gone)
+	select {
+	case response := <-returned:
+		if response != nil {
+			t.Errorf("QPOP of a client gone = %q, want no reply", response)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("QPOP still blocked after its client went away")
+	}
+
+	//the message pushed afterwards is not leased to the client gone
+	run(t, db, "QPUSH", "q", "a")
+	want := "*10\r\n+ready\r\n:1\r\n+delayed\r\n:0\r\n+leased\r\n:0\r\n+dead\r\n:0\r\n+pushed\r\n:1\r\n"
+	if got := run(t, db, "QSTATS", "q"); got != want {
+		t.Errorf("QSTATS q = %q, want %q", got, want)
+	}
+	if n := len(db.waiters.keys); n != 0 {
+		t.Errorf("%d keys still waited on", n)
+	}
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Handles the parameters for GETVER command
+func (c *Context) getVersion(params []string) ([]byte, error) {
+	//KEY
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR GETVER command requires a key\r\n")
+	}
+
+	kv, exists, err := c.db.getSegment(params[0]).lookupString(params[0])
+	if err != nil {
+		return []byte(""), err
+	}
+	if !exists {
+		return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+	}
+	return []byte(fmt.Sprintf("*2\r\n+%s\r\n:%d\r\n", kv.Value, kv.Version)), nil
+}
+
+// Handles the parameters for CAS command
+func (c *Context) compareAndSet(params []string) ([]byte, error) {
+	//KEY VERSION VALUE [EX 10|PX 10000|EXAT ts|PXAT ts|KEEPTTL]
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR CAS command requires key, version and value\r\n")
+	}
+
+	expected, err := strconv.ParseUint(params[1], 10, 64)
+	if err != nil {
+		return []byte(""), errors.New("-ERR version is not an integer or out of range\r\n")
+	}
+
+	//conditions and GET belong to SET, only the expiry options apply here
+	opts, err := parseSetOptions(params[3:])
+	if err != nil {
+		return []byte(""), err
+	}
+	if opts.nx || opts.xx || opts.get {
+		return []byte(""), errors.New("-ERR syntax error\r\n")
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+
+	//a missing key has version 0, so CAS with 0 only creates
+	old, exists := seg.lookup(key)
+	if old.Version != expected {
+		return []byte(":0\r\n"), nil
+	}
+
+	expireAt := opts.expireAt
+	if opts.keepTTL && exists {
+		expireAt = old.ExpireAt
+	}
+	version := c.setValue(seg, key, []byte(params[2]), expireAt)
+	return []byte(fmt.Sprintf(":%d\r\n", version)), nil
+}
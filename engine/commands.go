@@ -4,118 +4,400 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // Handles the parameters for PING command
-func (db *Store) Ping() ([]byte, error) {
+func (c *Context) ping(params []string) ([]byte, error) {
 	return []byte("+PONG\r\n"), nil
 }
 
 // Handles the parameters for GET command
-func (db *Store) Get(params []string) ([]byte, error) {
+func (c *Context) get(params []string) ([]byte, error) {
 	//KEY
 	if len(params) < 1 {
 		return []byte(""), errors.New("GET command requires a key")
 	}
 
 	key := params[0]
-	seg := db.getSegment(key)
+	seg := c.db.getSegment(key)
 
-	seg.mutex.RLock()
-	defer seg.mutex.RUnlock()
+	kv, exists, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	return valueReply(kv, exists), nil
+}
 
-	if kv, exists := seg.kv[key]; exists {
-		// Check if key has expired
-		if kv.ExpireAt != 0 && time.Now().Unix() > kv.ExpireAt {
-			//Remove key from db
-			delete(seg.kv, key)
-			return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+// valueReply encodes a looked up value the way GET replies with it
+func valueReply(kv KeyValue, exists bool) []byte {
+	if !exists {
+		return []byte(fmt.Sprintf("+%s\r\n", "(nil)"))
+	}
+	return []byte(fmt.Sprintf("+%s\r\n", kv.Value))
+}
+
+// setOptions holds the parsed options of a SET command
+type setOptions struct {
+	nx       bool  // only set if the key does not exist
+	xx       bool  // only set if the key already exists
+	get      bool  // reply with the old value instead of OK
+	keepTTL  bool  // retain the expiry of the existing key
+	expireAt int64 // absolute expiry in Unix seconds, 0 means none
+}
+
+// parseSetOptions parses the options following SET key value
+// [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT ms-timestamp|KEEPTTL]
+func parseSetOptions(args []string) (setOptions, error) {
+	var opts setOptions
+	expirySet := false
+
+	for i := 0; i < len(args); i++ {
+		switch option := strings.ToUpper(args[i]); option {
+		case "NX":
+			opts.nx = true
+		case "XX":
+			opts.xx = true
+		case "GET":
+			opts.get = true
+		case "KEEPTTL":
+			if expirySet {
+				return opts, errors.New("-ERR syntax error\r\n")
+			}
+			opts.keepTTL, expirySet = true, true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expirySet || i+1 >= len(args) {
+				return opts, errors.New("-ERR syntax error\r\n")
+			}
+			expireAt, err := parseExpiry(option, args[i+1])
+			if err != nil {
+				return opts, err
+			}
+			opts.expireAt, expirySet = expireAt, true
+			i++
+		default:
+			return opts, errors.New("-ERR syntax error\r\n")
 		}
+	}
 
-		return []byte(fmt.Sprintf("+%s\r\n", kv.Value)), nil
+	if opts.nx && opts.xx {
+		return opts, errors.New("-ERR syntax error\r\n")
 	}
+	return opts, nil
+}
 
-	return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+// parseExpiry converts an EX/PX/EXAT/PXAT argument into an absolute Unix
+// timestamp in seconds. Expiry is tracked at second granularity, so
+// millisecond values are rounded up to the next whole second.
+func parseExpiry(option string, arg string) (int64, error) {
+	//base10, should fit in int64
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("-ERR invalid expire time\r\n")
+	}
+
+	switch option {
+	case "EX":
+		return time.Now().Unix() + n, nil
+	case "PX":
+		return time.Now().Unix() + (n+999)/1000, nil
+	case "EXAT":
+		return n, nil
+	case "PXAT":
+		return (n + 999) / 1000, nil
+	}
+	return 0, errors.New("-ERR syntax error\r\n")
 }
 
 // Handles the parameters for SET command
-func (db *Store) Set(params []string) ([]byte, error) {
-	//KEY VALUE EX 10
+func (c *Context) set(params []string) ([]byte, error) {
+	//KEY VALUE [NX|XX] [GET] [EX 10|PX 10000|EXAT ts|PXAT ts|KEEPTTL]
 	if len(params) < 2 {
 		return []byte(""), errors.New("-ERR SET command requires key and value\r\n")
 	}
 
+	opts, err := parseSetOptions(params[2:])
+	if err != nil {
+		return []byte(""), err
+	}
+
 	key, value := params[0], []byte(params[1])
-	seg := db.getSegment(key)
-
-	fmt.Println("Waiting for Lock !")
-	seg.mutex.Lock()
-	fmt.Println("Granted")
-	defer seg.mutex.Unlock()
-
-	var expireAt int64 = 0
-	//Check for Expiry
-	if len(params) > 3 && params[2] == "EX" {
-		//base10, should fit in int64
-		seconds, err := strconv.ParseInt(params[3], 10, 64)
-		if err != nil {
-			return []byte(""), errors.New("-ERR invalid expire time\r\n")
+	seg := c.db.getSegment(key)
+
+	old, exists := seg.lookup(key)
+	if opts.get && exists && !old.isString() {
+		return []byte(""), errWrongType
+	}
+
+	//NX/XX conditions are checked under the same lock as the write
+	if (opts.nx && exists) || (opts.xx && !exists) {
+		if opts.get {
+			return valueReply(old, exists), nil
 		}
-		expireAt = time.Now().Unix() + seconds
+		return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+	}
+
+	expireAt := opts.expireAt
+	if opts.keepTTL && exists {
+		expireAt = old.ExpireAt
 	}
+	c.setValue(seg, key, value, expireAt)
 
-	seg.kv[key] = KeyValue{
+	if opts.get {
+		return valueReply(old, exists), nil
+	}
+	return []byte("+OK\r\n"), nil
+}
+
+// setValue stores the value and logs it to the WAL, returning its new version
+func (c *Context) setValue(seg *segment, key string, value []byte, expireAt int64) uint64 {
+	version := c.put(seg, key, KeyValue{
 		Value:    value,
 		ExpireAt: expireAt,
+	})
+	c.log(WALRecord{Command: "SET", Key: key, Value: value, ExpireAt: expireAt, Version: version})
+	return version
+}
+
+// Handles the parameters for SETNX command
+func (c *Context) setNX(params []string) ([]byte, error) {
+	//KEY VALUE
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR SETNX command requires key and value\r\n")
 	}
 
-	return []byte("+OK\r\n"), nil
+	key, value := params[0], []byte(params[1])
+	seg := c.db.getSegment(key)
+
+	if _, exists := seg.lookup(key); exists {
+		return []byte(":0\r\n"), nil
+	}
+
+	c.setValue(seg, key, value, 0)
+	return []byte(":1\r\n"), nil
+}
+
+// Handles the parameters for GETSET command
+func (c *Context) getSet(params []string) ([]byte, error) {
+	//KEY VALUE
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR GETSET command requires key and value\r\n")
+	}
+
+	key, value := params[0], []byte(params[1])
+	seg := c.db.getSegment(key)
+
+	old, exists, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	c.setValue(seg, key, value, 0)
+
+	return valueReply(old, exists), nil
 }
 
-// Handles the parameters for DEL command
-func (db *Store) Del(params []string) ([]byte, error) {
+// Handles the parameters for GETDEL command
+func (c *Context) getDel(params []string) ([]byte, error) {
 	//KEY
 	if len(params) < 1 {
-		return []byte(""), errors.New("-ERR DEL command requires at least one key\r\n")
+		return []byte(""), errors.New("-ERR GETDEL command requires a key\r\n")
 	}
 
 	key := params[0]
-	seg := db.getSegment(key)
-
-	seg.mutex.Lock()
-	defer seg.mutex.Unlock()
+	seg := c.db.getSegment(key)
 
-	if _, exists := seg.kv[key]; exists {
+	old, exists, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if exists {
 		delete(seg.kv, key)
-		return []byte(":1\r\n"), nil // Returns 1 if key was deleted
+		c.log(WALRecord{Command: "DEL", Key: key})
+	}
+
+	return valueReply(old, exists), nil
+}
+
+// Handles the parameters for GETEX command
+func (c *Context) getEx(params []string) ([]byte, error) {
+	//KEY [EX 10|PX 10000|EXAT ts|PXAT ts|PERSIST]
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR GETEX command requires a key\r\n")
+	}
+
+	key := params[0]
+	update, persist := false, false
+	var expireAt int64
+
+	switch len(params) {
+	case 1:
+	case 2:
+		if strings.ToUpper(params[1]) != "PERSIST" {
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+		update, persist = true, true
+	case 3:
+		option := strings.ToUpper(params[1])
+		if option != "EX" && option != "PX" && option != "EXAT" && option != "PXAT" {
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+		var err error
+		if expireAt, err = parseExpiry(option, params[2]); err != nil {
+			return []byte(""), err
+		}
+		update = true
+	default:
+		return []byte(""), errors.New("-ERR syntax error\r\n")
 	}
 
-	return []byte(":0\r\n"), nil // Returns 0 if key didn't exist
+	seg := c.db.getSegment(key)
+
+	kv, exists, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if exists && update {
+		if persist {
+			expireAt = 0
+		}
+		kv.ExpireAt = expireAt
+		version := c.put(seg, key, kv)
+		c.log(WALRecord{Command: "EXPIRE", Key: key, ExpireAt: expireAt, Version: version})
+	}
+
+	return valueReply(kv, exists), nil
+}
+
+// Handles the parameters for MGET command
+func (c *Context) mget(params []string) ([]byte, error) {
+	//KEY [KEY ...]
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR MGET command requires at least one key\r\n")
+	}
+
+	response := []byte(fmt.Sprintf("*%d\r\n", len(params)))
+	for _, key := range params {
+		//values of other types read as missing, as in redis
+		kv, exists, err := c.db.getSegment(key).lookupString(key)
+		response = append(response, valueReply(kv, exists && err == nil)...)
+	}
+
+	return response, nil
+}
+
+// Handles the parameters for MSET command
+func (c *Context) mset(params []string) ([]byte, error) {
+	//KEY VALUE [KEY VALUE ...]
+	if len(params) < 2 || len(params)%2 != 0 {
+		return []byte(""), errors.New("-ERR MSET command requires key value pairs\r\n")
+	}
+
+	for i := 0; i < len(params); i += 2 {
+		c.setValue(c.db.getSegment(params[i]), params[i], []byte(params[i+1]), 0)
+	}
+
+	return []byte("+OK\r\n"), nil
+}
+
+// Handles the parameters for MSETNX command
+func (c *Context) msetNX(params []string) ([]byte, error) {
+	//KEY VALUE [KEY VALUE ...]
+	if len(params) < 2 || len(params)%2 != 0 {
+		return []byte(""), errors.New("-ERR MSETNX command requires key value pairs\r\n")
+	}
+
+	//every involved segment is held, so the existence check and the writes are atomic
+	keys := pairKeys(params)
+	for _, key := range keys {
+		if _, exists := c.db.getSegment(key).lookup(key); exists {
+			return []byte(":0\r\n"), nil
+		}
+	}
+
+	for i := 0; i < len(params); i += 2 {
+		c.setValue(c.db.getSegment(params[i]), params[i], []byte(params[i+1]), 0)
+	}
+
+	return []byte(":1\r\n"), nil
+}
+
+// pairKeys returns the keys of a KEY VALUE [KEY VALUE ...] parameter list
+func pairKeys(params []string) []string {
+	keys := make([]string, 0, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		keys = append(keys, params[i])
+	}
+	return keys
+}
+
+// Handles the parameters for DEL (and UNLINK) command
+func (c *Context) del(params []string) ([]byte, error) {
+	//KEY [KEY ...]
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR DEL command requires at least one key\r\n")
+	}
+
+	deleted := 0
+	for _, key := range params {
+		seg := c.db.getSegment(key)
+		if _, exists := seg.lookup(key); exists {
+			delete(seg.kv, key)
+			c.log(WALRecord{Command: "DEL", Key: key})
+			deleted++
+		}
+	}
+
+	return []byte(fmt.Sprintf(":%d\r\n", deleted)), nil // Number of keys that were deleted
+}
+
+// Handles the parameters for EXISTS command
+func (c *Context) exists(params []string) ([]byte, error) {
+	//KEY [KEY ...]
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR EXISTS command requires at least one key\r\n")
+	}
+
+	return []byte(fmt.Sprintf(":%d\r\n", c.countExisting(params))), nil
+}
+
+// Handles the parameters for TOUCH command
+func (c *Context) touch(params []string) ([]byte, error) {
+	//KEY [KEY ...]
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR TOUCH command requires at least one key\r\n")
+	}
+
+	//no access time is tracked, so touching a key only reports its existence
+	return []byte(fmt.Sprintf(":%d\r\n", c.countExisting(params))), nil
+}
+
+// countExisting counts the keys that exist, counting repeated keys every time
+func (c *Context) countExisting(keys []string) int {
+	count := 0
+	for _, key := range keys {
+		if _, exists := c.db.getSegment(key).lookup(key); exists {
+			count++
+		}
+	}
+	return count
 }
 
 // Handles the parameters for TTL command
-func (db *Store) TTL(params []string) ([]byte, error) {
+func (c *Context) ttl(params []string) ([]byte, error) {
 	//KEY
 	if len(params) < 1 {
 		return []byte(""), errors.New("-ERR TTL command requires a key\r\n")
 	}
 
 	key := params[0]
-	seg := db.getSegment(key)
-
-	seg.mutex.RLock()
-	defer seg.mutex.RUnlock()
+	seg := c.db.getSegment(key)
 
-	if kv, exists := seg.kv[key]; exists {
+	if kv, exists := seg.lookup(key); exists {
 
 		//check if no expiration is set
 		if kv.ExpireAt == 0 {
 			return []byte(":-1\r\n"), nil
-		} else if kv.ExpireAt != 0 && time.Now().Unix() > kv.ExpireAt { // Check if key has expired
-			//Remove key from db
-			delete(seg.kv, key)
-			return []byte(":-2\r\n"), nil
 		}
 
 		// Calculate TTL
@@ -127,14 +409,14 @@ func (db *Store) TTL(params []string) ([]byte, error) {
 }
 
 // Handles the parameters for EXPIRE command
-func (db *Store) Expire(params []string) ([]byte, error) {
+func (c *Context) expire(params []string) ([]byte, error) {
 	//abc 10
 	if len(params) < 2 {
 		return []byte(""), errors.New("-ERR EXPIRE command requires key and seconds")
 	}
 
 	key := params[0]
-	seg := db.getSegment(key)
+	seg := c.db.getSegment(key)
 
 	//base10, should fit in int64
 	seconds, err := strconv.ParseInt(params[1], 10, 64)
@@ -142,12 +424,10 @@ func (db *Store) Expire(params []string) ([]byte, error) {
 		return []byte(""), errors.New("-ERR invalid expire time")
 	}
 
-	seg.mutex.Lock()
-	defer seg.mutex.Unlock()
-
-	if value, exists := seg.kv[key]; exists {
+	if value, exists := seg.lookup(key); exists {
 		value.ExpireAt = time.Now().Unix() + seconds
-		seg.kv[key] = value
+		version := c.put(seg, key, value)
+		c.log(WALRecord{Command: "EXPIRE", Key: key, ExpireAt: value.ExpireAt, Version: version})
 		return []byte(":1\r\n"), nil
 	}
 
@@ -156,15 +436,13 @@ func (db *Store) Expire(params []string) ([]byte, error) {
 }
 
 // Handles the parameters for FLUSHDB command
-func (db *Store) FlushDB() ([]byte, error) {
-	//lock all segments for a complete flush
-	for _, seg := range db.segments {
-		seg.mutex.Lock()
-		defer seg.mutex.Unlock()
-
+func (c *Context) flushDB(params []string) ([]byte, error) {
+	//every segment is locked for a complete flush
+	for _, seg := range c.db.segments {
 		// Clear the map
 		seg.kv = make(map[string]KeyValue)
 	}
+	c.log(WALRecord{Command: "FLUSHDB"})
 
 	return []byte("+OK\r\n"), nil
 }
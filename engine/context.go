@@ -0,0 +1,89 @@
+package engine
+
+// Context is the state of one or more commands running with their segments
+// already locked. The WAL records the commands produce are buffered and
+// written as a single batch once they are done.
+//
+// A command handler may only touch the keys at its declared key positions,
+// as those are the only segments locked for it.
+type Context struct {
+	db        *Store
+	allLocked bool // every segment is held, so self-locking commands must not lock
+	canBlock  bool // blocking commands may wait, which they must not inside MULTI or a script
+	authorize Authorizer
+	records   []WALRecord
+}
+
+// Authorizer checks whether the client may run a command called from a
+// script, returning the error to fail the call with. The server checks the
+// commands clients send it before they reach the engine.
+type Authorizer func(cmd *Command, args []string) error
+
+// Lookup returns the live value stored under key
+func (c *Context) Lookup(key string) (KeyValue, bool) {
+	return c.db.getSegment(key).lookup(key)
+}
+
+// Put stores kv under key and logs it to the WAL
+func (c *Context) Put(key string, kv KeyValue) {
+	version := c.put(c.db.getSegment(key), key, kv)
+	c.log(WALRecord{Command: "SET", Key: key, Value: kv.Value, ExpireAt: kv.ExpireAt, Version: version, Stream: kv.Stream.clone(), Queue: kv.Queue.clone(), Lock: kv.Lock})
+}
+
+// Delete removes key, logging it to the WAL, and reports whether it existed
+func (c *Context) Delete(key string) bool {
+	seg := c.db.getSegment(key)
+	if _, exists := seg.lookup(key); !exists {
+		return false
+	}
+	delete(seg.kv, key)
+	c.log(WALRecord{Command: "DEL", Key: key})
+	return true
+}
+
+// put stores kv under key with a fresh version, which it returns so the
+// WAL record can carry it
+func (c *Context) put(seg *segment, key string, kv KeyValue) uint64 {
+	kv.Version = c.db.versionClock.Add(1)
+	seg.kv[key] = kv
+	return kv.Version
+}
+
+// log buffers a WAL record for the write that was just applied
+func (c *Context) log(record WALRecord) {
+	c.records = append(c.records, record)
+}
+
+// flush writes the buffered WAL records, logging (not returning) any failure,
+// then publishes the keyspace notifications of the writes and wakes the
+// commands blocked on the written keys
+func (c *Context) flush() {
+	if len(c.records) == 0 {
+		return
+	}
+	if c.db.persistenceManager != nil {
+		if err := c.db.persistenceManager.WriteWALBatch(c.records); err != nil {
+			c.db.log.Error("Failed to write to WAL", "err", err) // Log the error, but don't return it
+		}
+	}
+	for _, record := range c.records {
+		c.db.notifyWrite(record)
+		c.db.waiters.signal(record.Key)
+	}
+	c.records = nil
+}
+
+// rlock read-locks a segment for commands that walk the segments one at a
+// time, unless the whole keyspace is already held
+func (c *Context) rlock(seg *segment) {
+	if !c.allLocked {
+		seg.mutex.RLock()
+	}
+}
+
+// runlock releases a segment locked by rlock
+func (c *Context) runlock(seg *segment) {
+	if !c.allLocked {
+		seg.mutex.RUnlock()
+	}
+}
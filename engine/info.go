@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// storeStats counts what INFO reports of the keyspace and the snapshots
+type storeStats struct {
+	keyspaceHits   atomic.Int64
+	keyspaceMisses atomic.Int64
+	expiredKeys    atomic.Int64
+
+	snapshotMutex        sync.Mutex
+	snapshots            int64
+	lastSnapshotAt       time.Time
+	lastSnapshotOK       bool
+	lastSnapshotDuration time.Duration
+}
+
+func newStoreStats() *storeStats {
+	return &storeStats{lastSnapshotOK: true}
+}
+
+// recordSnapshot records how the last snapshot went
+func (st *storeStats) recordSnapshot(start time.Time, err error) {
+	st.snapshotMutex.Lock()
+	defer st.snapshotMutex.Unlock()
+	st.snapshots++
+	st.lastSnapshotAt = start
+	st.lastSnapshotOK = err == nil
+	st.lastSnapshotDuration = time.Since(start)
+}
+
+// countLookups counts the keys of a read-only command as hits or misses,
+// before it runs with their segments locked
+func (c *Context) countLookups(cmd *Command, params []string) {
+	if cmd.Flags&FlagReadOnly == 0 {
+		return
+	}
+	for _, key := range cmd.KeysOf(params) {
+		if _, exists := c.db.getSegment(key).lookup(key); exists {
+			c.db.stats.keyspaceHits.Add(1)
+		} else {
+			c.db.stats.keyspaceMisses.Add(1)
+		}
+	}
+}
+
+// keyExpired counts a key the cleanup loop removed, and notifies it
+func (s *Store) keyExpired(key string) {
+	s.stats.expiredKeys.Add(1)
+	s.notifyExpired(key)
+}
+
+// approxSize estimates the bytes a key and its value take
+func approxSize(key string, kv KeyValue) int {
+	size := len(key) + len(kv.Value) + 64
+	if kv.Stream != nil {
+		for _, entry := range kv.Stream.Entries {
+			size += 32
+			for _, field := range entry.Fields {
+				size += len(field)
+			}
+		}
+		for name, group := range kv.Stream.Groups {
+			size += len(name) + 48*len(group.Pending) + 32*len(group.Consumers)
+		}
+	}
+	if kv.Queue != nil {
+		for _, msg := range kv.Queue.Messages {
+			size += 48 + len(msg.Payload)
+		}
+		for _, msg := range kv.Queue.Dead {
+			size += 48 + len(msg.Payload)
+		}
+	}
+	if kv.Lock != nil {
+		size += 32 + len(kv.Lock.Owner)
+	}
+	return size
+}
+
+// segmentStats are the key counts of a segment
+type segmentStats struct {
+	keys, expires int
+	ttlSum        int64 // seconds left of the keys with a TTL
+	bytes         int
+}
+
+// keyspaceStats reads the key counts of every segment, one at a time
+func (s *Store) keyspaceStats() []segmentStats {
+	now := time.Now().Unix()
+	stats := make([]segmentStats, len(s.segments))
+	for i, seg := range s.segments {
+		seg.mutex.RLock()
+		for k, v := range seg.kv {
+			if v.expired(now) {
+				continue
+			}
+			stats[i].keys++
+			stats[i].bytes += approxSize(k, v)
+			if v.ExpireAt != 0 {
+				stats[i].expires++
+				stats[i].ttlSum += v.ExpireAt - now
+			}
+		}
+		seg.mutex.RUnlock()
+	}
+	return stats
+}
+
+// InfoSections are the sections of INFO the store reports, in order
+var InfoSections = []string{"memory", "persistence", "stats", "keyspace"}
+
+// Info returns the fields of one of InfoSections, as "name:value" lines
+func (s *Store) Info(section string) []string {
+	switch section {
+	case "memory":
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		dataset := 0
+		for _, st := range s.keyspaceStats() {
+			dataset += st.bytes
+		}
+		return []string{
+			fmt.Sprintf("used_memory:%d", mem.HeapAlloc),
+			fmt.Sprintf("used_memory_human:%s", humanBytes(mem.HeapAlloc)),
+			fmt.Sprintf("used_memory_sys:%d", mem.Sys),
+			fmt.Sprintf("used_memory_sys_human:%s", humanBytes(mem.Sys)),
+			fmt.Sprintf("used_memory_dataset:%d", dataset),
+			fmt.Sprintf("used_memory_dataset_human:%s", humanBytes(uint64(dataset))),
+			fmt.Sprintf("gc_cycles:%d", mem.NumGC),
+		}
+
+	case "persistence":
+		walSize, lastRotation, rotations := s.persistenceManager.WALStats()
+		st := s.stats
+		st.snapshotMutex.Lock()
+		defer st.snapshotMutex.Unlock()
+		status := "ok"
+		if !st.lastSnapshotOK {
+			status = "err"
+		}
+		return []string{
+			fmt.Sprintf("wal_current_size:%d", walSize),
+			fmt.Sprintf("wal_rotations:%d", rotations),
+			fmt.Sprintf("wal_last_rotation_time:%d", unixOrZero(lastRotation)),
+			fmt.Sprintf("snapshots:%d", st.snapshots),
+			fmt.Sprintf("last_snapshot_time:%d", unixOrZero(st.lastSnapshotAt)),
+			fmt.Sprintf("last_snapshot_status:%s", status),
+			fmt.Sprintf("last_snapshot_duration_ms:%d", st.lastSnapshotDuration.Milliseconds()),
+		}
+
+	case "stats":
+		hits, misses := s.stats.keyspaceHits.Load(), s.stats.keyspaceMisses.Load()
+		ratio := 0.0
+		if hits+misses > 0 {
+			ratio = float64(hits) / float64(hits+misses)
+		}
+		return []string{
+			fmt.Sprintf("expired_keys:%d", s.stats.expiredKeys.Load()),
+			"evicted_keys:0", //keys are never evicted, there is no memory limit
+			fmt.Sprintf("keyspace_hits:%d", hits),
+			fmt.Sprintf("keyspace_misses:%d", misses),
+			fmt.Sprintf("keyspace_hit_ratio:%.4f", ratio),
+			fmt.Sprintf("pubsub_channels:%d", len(s.broker.ActiveChannels(""))),
+			fmt.Sprintf("pubsub_patterns:%d", s.broker.NumPat()),
+		}
+
+	case "keyspace":
+		var total segmentStats
+		lines := []string{}
+		for i, st := range s.keyspaceStats() {
+			total.keys += st.keys
+			total.expires += st.expires
+			total.ttlSum += st.ttlSum
+			lines = append(lines, fmt.Sprintf("segment%d:keys=%d,expires=%d", i, st.keys, st.expires))
+		}
+		avgTTL := int64(0)
+		if total.expires > 0 {
+			avgTTL = total.ttlSum * 1000 / int64(total.expires)
+		}
+		return append([]string{fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=%d", total.keys, total.expires, avgTTL)}, lines...)
+	}
+	return nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// humanBytes formats a byte count the way INFO shows it, e.g. 1.50M
+func humanBytes(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value, unit := float64(n), 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.2f%s", value, units[unit])
+}
@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Handles the parameters for COMMAND command
+func (c *Context) command(params []string) ([]byte, error) {
+	//[COUNT | INFO [name ...] | DOCS [name ...]]
+	if len(params) == 0 {
+		return commandInfos(commands()), nil
+	}
+
+	switch strings.ToUpper(params[0]) {
+	case "COUNT":
+		if len(params) != 1 {
+			return []byte(""), errors.New("-ERR wrong number of arguments for 'command|count' command\r\n")
+		}
+		return []byte(fmt.Sprintf(":%d\r\n", len(commands()))), nil
+	case "INFO":
+		if len(params) == 1 {
+			return commandInfos(commands()), nil
+		}
+		//unknown names get a nil entry, as in redis
+		response := []byte(fmt.Sprintf("*%d\r\n", len(params)-1))
+		for _, name := range params[1:] {
+			if cmd, exists := LookupCommand(name); exists {
+				response = append(response, commandInfo(cmd)...)
+			} else {
+				response = append(response, []byte("+(nil)\r\n")...)
+			}
+		}
+		return response, nil
+	case "DOCS":
+		all := commands()
+		if len(params) > 1 {
+			//unknown names are left out
+			all = all[:0]
+			for _, name := range params[1:] {
+				if cmd, exists := LookupCommand(name); exists {
+					all = append(all, cmd)
+				}
+			}
+		}
+		response := []byte(fmt.Sprintf("*%d\r\n", 2*len(all)))
+		for _, cmd := range all {
+			response = append(response, commandDocs(cmd)...)
+		}
+		return response, nil
+	}
+	return []byte(""), fmt.Errorf("-ERR unknown subcommand '%s'. Try COMMAND INFO or COMMAND DOCS.\r\n", params[0])
+}
+
+// commandInfos encodes the COMMAND INFO entries of several commands
+func commandInfos(all []*Command) []byte {
+	response := []byte(fmt.Sprintf("*%d\r\n", len(all)))
+	for _, cmd := range all {
+		response = append(response, commandInfo(cmd)...)
+	}
+	return response
+}
+
+// commandInfo encodes name, arity, flags and key positions of a command
+func commandInfo(cmd *Command) []byte {
+	flags := []string{}
+	for _, f := range flagNames {
+		if cmd.Flags&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+
+	response := []byte(fmt.Sprintf("*6\r\n+%s\r\n:%d\r\n*%d\r\n", strings.ToLower(cmd.Name), cmd.Arity, len(flags)))
+	for _, flag := range flags {
+		response = append(response, []byte(fmt.Sprintf("+%s\r\n", flag))...)
+	}
+	return append(response, []byte(fmt.Sprintf(":%d\r\n:%d\r\n:%d\r\n", cmd.FirstKey, cmd.LastKey, cmd.KeyStep))...)
+}
+
+// commandDocs encodes the name of a command followed by its docs
+func commandDocs(cmd *Command) []byte {
+	return []byte(fmt.Sprintf("+%s\r\n*4\r\n+summary\r\n+%s\r\n+group\r\n+%s\r\n",
+		strings.ToLower(cmd.Name), cmd.Summary, cmd.Group))
+}
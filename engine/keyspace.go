@@ -0,0 +1,312 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"tempDB/utils"
+	"time"
+)
+
+// typeName returns the name TYPE reports for the value
+func (kv KeyValue) typeName() string {
+	switch {
+	case kv.Stream != nil:
+		return "stream"
+	case kv.Queue != nil:
+		return "queue"
+	case kv.Lock != nil:
+		return "lock"
+	}
+	return "string"
+}
+
+// isString reports whether the value is a string rather than another data type
+func (kv KeyValue) isString() bool {
+	return kv.Stream == nil && kv.Queue == nil && kv.Lock == nil
+}
+
+// Handles the parameters for TYPE command
+func (c *Context) keyType(params []string) ([]byte, error) {
+	//KEY
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR TYPE command requires a key\r\n")
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+
+	if kv, exists := seg.lookup(key); exists {
+		return []byte(fmt.Sprintf("+%s\r\n", kv.typeName())), nil
+	}
+	return []byte("+none\r\n"), nil
+}
+
+// Handles the parameters for DBSIZE command
+func (c *Context) dbSize(params []string) ([]byte, error) {
+	//keys that expired but are not cleaned up yet are skipped, so the size
+	//agrees with EXISTS, SCAN and INFO
+	now := time.Now().Unix()
+	size := 0
+	for _, seg := range c.db.segments {
+		c.rlock(seg)
+		for _, kv := range seg.kv {
+			if !kv.expired(now) {
+				size++
+			}
+		}
+		c.runlock(seg)
+	}
+
+	return []byte(fmt.Sprintf(":%d\r\n", size)), nil
+}
+
+// Handles the parameters for RANDOMKEY command
+func (c *Context) randomKey(params []string) ([]byte, error) {
+	//start at a random segment and take the first live key found,
+	//map iteration order already being random within a segment
+	start := rand.Intn(len(c.db.segments))
+	for i := range c.db.segments {
+		seg := c.db.segments[(start+i)%len(c.db.segments)]
+
+		c.rlock(seg)
+		for key := range seg.kv {
+			if _, exists := seg.lookup(key); exists {
+				c.runlock(seg)
+				return []byte(fmt.Sprintf("+%s\r\n", key)), nil
+			}
+		}
+		c.runlock(seg)
+	}
+
+	return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+}
+
+// Handles the parameters for RENAME command
+func (c *Context) rename(params []string) ([]byte, error) {
+	return c.renameKey(params, false)
+}
+
+// Handles the parameters for RENAMENX command
+func (c *Context) renameNX(params []string) ([]byte, error) {
+	return c.renameKey(params, true)
+}
+
+// renameKey moves src to dst, only if dst does not exist when nx is set
+func (c *Context) renameKey(params []string, nx bool) ([]byte, error) {
+	//SRC DST
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR RENAME command requires source and destination keys\r\n")
+	}
+
+	src, dst := params[0], params[1]
+
+	//both segments are held so the move is atomic
+	srcSeg, dstSeg := c.db.getSegment(src), c.db.getSegment(dst)
+	kv, exists := srcSeg.lookup(src)
+	if !exists {
+		return []byte(""), errors.New("-ERR no such key\r\n")
+	}
+
+	if nx {
+		if _, taken := dstSeg.lookup(dst); taken {
+			return []byte(":0\r\n"), nil
+		}
+	}
+
+	if src != dst {
+		delete(srcSeg.kv, src)
+		version := c.put(dstSeg, dst, kv) //the TTL moves along with the value
+		c.log(WALRecord{Command: "RENAME", Key: src, Value: []byte(dst), Version: version})
+	}
+
+	if nx {
+		return []byte(":1\r\n"), nil
+	}
+	return []byte("+OK\r\n"), nil
+}
+
+// Handles the parameters for COPY command
+func (c *Context) copyKey(params []string) ([]byte, error) {
+	//SRC DST [REPLACE]
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR COPY command requires source and destination keys\r\n")
+	}
+
+	replace := false
+	for _, option := range params[2:] {
+		if strings.ToUpper(option) != "REPLACE" {
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+		replace = true
+	}
+
+	src, dst := params[0], params[1]
+	if src == dst {
+		return []byte(""), errors.New("-ERR source and destination objects are the same\r\n")
+	}
+
+	kv, exists := c.db.getSegment(src).lookup(src)
+	if !exists {
+		return []byte(":0\r\n"), nil
+	}
+
+	dstSeg := c.db.getSegment(dst)
+	if _, taken := dstSeg.lookup(dst); taken && !replace {
+		return []byte(":0\r\n"), nil
+	}
+
+	//the copy gets its own backing array (or stream or queue) and keeps the source TTL
+	c.Put(dst, KeyValue{
+		Value:    append([]byte(nil), kv.Value...),
+		ExpireAt: kv.ExpireAt,
+		Stream:   kv.Stream.clone(),
+		Queue:    kv.Queue.clone(),
+		Lock:     kv.Lock,
+	})
+
+	return []byte(":1\r\n"), nil
+}
+
+// scanOptions holds the parsed options of a SCAN command
+type scanOptions struct {
+	match    string // glob the keys must match, empty for all
+	count    int    // number of keys to examine per call
+	typeName string // type the values must have, empty for all
+}
+
+// Handles the parameters for SCAN command
+//
+// The cursor is stateless: its upper 32 bits hold the segment index and its
+// lower 32 bits the key hash to resume from within that segment. Keys are
+// visited in hash order, so a key present for the whole scan is returned
+// whatever is inserted or deleted around it, and each call only holds one
+// segment's read lock at a time. Entering a segment sorts its keys once, so
+// the calls that follow only read the next count keys from there.
+func (c *Context) scan(params []string) ([]byte, error) {
+	//CURSOR [MATCH pattern] [COUNT count] [TYPE type]
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR SCAN command requires a cursor\r\n")
+	}
+
+	cursor, err := strconv.ParseUint(params[0], 10, 64)
+	if err != nil {
+		return []byte(""), errors.New("-ERR invalid cursor\r\n")
+	}
+
+	opts := scanOptions{count: 10}
+	for i := 1; i < len(params); i += 2 {
+		if i+1 >= len(params) {
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+		switch strings.ToUpper(params[i]) {
+		case "MATCH":
+			opts.match = params[i+1]
+		case "COUNT":
+			opts.count, err = strconv.Atoi(params[i+1])
+			if err != nil || opts.count < 1 {
+				return []byte(""), errors.New("-ERR syntax error\r\n")
+			}
+		case "TYPE":
+			opts.typeName = strings.ToLower(params[i+1])
+		default:
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+	}
+
+	segIndex, position := cursor>>32, cursor&0xFFFFFFFF
+	keys := []string{}
+	examined := 0
+
+	for segIndex < uint64(len(c.db.segments)) && examined < opts.count {
+		seg := c.db.segments[segIndex]
+
+		c.rlock(seg)
+		batch, next, seen := seg.scan(position, opts, examined)
+		c.runlock(seg)
+
+		keys = append(keys, batch...)
+		examined = seen
+
+		if next > 0xFFFFFFFF {
+			//segment exhausted, move on to the next one
+			segIndex, position = segIndex+1, 0
+		} else {
+			position = next
+		}
+	}
+
+	cursor = 0
+	if segIndex < uint64(len(c.db.segments)) {
+		cursor = segIndex<<32 | position
+	}
+
+	response := []byte(fmt.Sprintf("*2\r\n+%d\r\n*%d\r\n", cursor, len(keys)))
+	for _, key := range keys {
+		response = append(response, []byte(fmt.Sprintf("+%s\r\n", key))...)
+	}
+	return response, nil
+}
+
+// hashedKey is a key of a segment along with its hash, the order SCAN visits keys in
+type hashedKey struct {
+	hash uint32
+	key  string
+}
+
+// scanIndex returns the keys of the segment sorted by hash, sorting them
+// again when rebuild is set or they never were. Keys added since the last
+// sort are missing and deleted ones are still there, which a scan tolerates:
+// every scan sorts again as it enters the segment, so a key present for its
+// whole duration is always in the index it walks. The read lock of the
+// segment must be held.
+func (seg *segment) scanIndex(rebuild bool) []hashedKey {
+	seg.scanMutex.Lock()
+	defer seg.scanMutex.Unlock()
+
+	if rebuild || seg.scanOrder == nil {
+		order := make([]hashedKey, 0, len(seg.kv))
+		for key := range seg.kv {
+			order = append(order, hashedKey{keyHash(key), key})
+		}
+		sort.Slice(order, func(i, j int) bool { return order[i].hash < order[j].hash })
+		seg.scanOrder = order
+	}
+	return seg.scanOrder
+}
+
+// scan examines the keys of the segment whose hash is at least position, in
+// hash order, until count keys have been examined in total. It returns the
+// matching keys, the position to resume from (past 0xFFFFFFFF once the
+// segment is exhausted) and the updated number of examined keys.
+func (seg *segment) scan(position uint64, opts scanOptions, examined int) ([]string, uint64, int) {
+	order := seg.scanIndex(position == 0)
+	start := sort.Search(len(order), func(i int) bool { return uint64(order[i].hash) >= position })
+
+	keys := []string{}
+	for i := start; i < len(order); i++ {
+		entry := order[i]
+		//never stop between keys sharing a hash, the cursor could not resume there
+		if examined >= opts.count && i > start && entry.hash != order[i-1].hash {
+			return keys, uint64(entry.hash), examined
+		}
+		examined++
+
+		kv, exists := seg.lookup(entry.key)
+		if !exists {
+			continue
+		}
+		if opts.match != "" && !utils.GlobMatch(opts.match, entry.key) {
+			continue
+		}
+		if opts.typeName != "" && kv.typeName() != opts.typeName {
+			continue
+		}
+		keys = append(keys, entry.key)
+	}
+
+	return keys, 0xFFFFFFFF + 1, examined
+}
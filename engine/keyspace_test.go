@@ -0,0 +1,22 @@
+package engine
+
+import "testing"
+
+func TestDBSizeSkipsExpiredKeys(t *testing.T) {
+	db := newTestStore(t)
+	run(t, db, "SET", "live", "v")
+	run(t, db, "SET", "ttl", "v", "EX", "100")
+
+	//a key that expired, which the cleanup loop has not removed yet
+	seg := db.getSegment("expired")
+	seg.mutex.Lock()
+	seg.kv["expired"] = KeyValue{Value: []byte("v"), ExpireAt: 1}
+	seg.mutex.Unlock()
+
+	if got := run(t, db, "DBSIZE"); got != ":2\r\n" {
+		t.Errorf("DBSIZE = %q, want %q", got, ":2\r\n")
+	}
+	if got := run(t, db, "EXISTS", "expired"); got != ":0\r\n" {
+		t.Errorf("EXISTS expired = %q, want %q", got, ":0\r\n")
+	}
+}
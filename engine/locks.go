@@ -0,0 +1,150 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Lock is a lease on a key held by an owner until it is released or its
+// lease ends. Its fencing token is the key version it was acquired with, so
+// tokens increase across every lock and, like versions, survive restarts.
+// Locks are never modified in place, a new one replaces the old.
+type Lock struct {
+	Owner     string
+	Token     uint64
+	ExpiresAt int64 // Unix milliseconds the lease ends
+}
+
+// lookupLock returns the lock held on a key, nil if the key does not exist
+// or its lease has ended
+func (seg *segment) lookupLock(key string) (KeyValue, *Lock, error) {
+	kv, exists := seg.lookup(key)
+	if !exists {
+		return kv, nil, nil
+	}
+	if kv.Lock == nil {
+		return kv, nil, errWrongType
+	}
+	if kv.Lock.ExpiresAt <= nowMs() {
+		return kv, nil, nil
+	}
+	return kv, kv.Lock, nil
+}
+
+// putLock stores a lock, also setting the key TTL (rounded up to the second)
+// so the cleanup loop removes the key once the lease has ended
+func (c *Context) putLock(seg *segment, key string, lock *Lock) uint64 {
+	kv := KeyValue{Lock: lock, ExpireAt: (lock.ExpiresAt + 999) / 1000}
+	version := c.put(seg, key, kv)
+	if lock.Token == 0 {
+		lock.Token = version
+	}
+	c.log(WALRecord{Command: "SET", Key: key, ExpireAt: kv.ExpireAt, Version: version, Lock: lock})
+	return lock.Token
+}
+
+// parseLease parses a lease length in milliseconds
+func parseLease(arg string) (int64, error) {
+	ttl, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || ttl < 1 {
+		return 0, errors.New("-ERR invalid lease time\r\n")
+	}
+	return ttl, nil
+}
+
+// Handles the parameters for LOCK command
+func (c *Context) lock(params []string) ([]byte, error) {
+	//KEY OWNER TTL-MS
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR LOCK command requires key, owner and lease time\r\n")
+	}
+
+	ttl, err := parseLease(params[2])
+	if err != nil {
+		return []byte(""), err
+	}
+
+	key, owner := params[0], params[1]
+	seg := c.db.getSegment(key)
+	_, held, err := seg.lookupLock(key)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	//acquiring a lock already held by the same owner extends it, keeping the token
+	lock := &Lock{Owner: owner, ExpiresAt: nowMs() + ttl}
+	if held != nil {
+		if held.Owner != owner {
+			return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+		}
+		lock.Token = held.Token
+	}
+
+	token := c.putLock(seg, key, lock)
+	return []byte(fmt.Sprintf(":%d\r\n", token)), nil
+}
+
+// Handles the parameters for UNLOCK command
+func (c *Context) unlock(params []string) ([]byte, error) {
+	//KEY OWNER
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR UNLOCK command requires key and owner\r\n")
+	}
+
+	key := params[0]
+	_, held, err := c.db.getSegment(key).lookupLock(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if held == nil || held.Owner != params[1] {
+		return []byte(":0\r\n"), nil
+	}
+
+	c.Delete(key)
+	return []byte(":1\r\n"), nil
+}
+
+// Handles the parameters for LOCKEXTEND command
+func (c *Context) lockExtend(params []string) ([]byte, error) {
+	//KEY OWNER TTL-MS
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR LOCKEXTEND command requires key, owner and lease time\r\n")
+	}
+
+	ttl, err := parseLease(params[2])
+	if err != nil {
+		return []byte(""), err
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+	_, held, err := seg.lookupLock(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if held == nil || held.Owner != params[1] {
+		return []byte(":0\r\n"), nil
+	}
+
+	c.putLock(seg, key, &Lock{Owner: held.Owner, Token: held.Token, ExpiresAt: nowMs() + ttl})
+	return []byte(":1\r\n"), nil
+}
+
+// Handles the parameters for LOCKINFO command
+func (c *Context) lockInfo(params []string) ([]byte, error) {
+	//KEY
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR LOCKINFO command requires a key\r\n")
+	}
+
+	_, held, err := c.db.getSegment(params[0]).lookupLock(params[0])
+	if err != nil {
+		return []byte(""), err
+	}
+	if held == nil {
+		return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+	}
+	return []byte(fmt.Sprintf("*6\r\n+owner\r\n+%s\r\n+token\r\n:%d\r\n+ttl\r\n:%d\r\n",
+		held.Owner, held.Token, held.ExpiresAt-nowMs())), nil
+}
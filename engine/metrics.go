@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"tempDB/metrics"
+	"time"
+)
+
+var (
+	commandCalls = metrics.NewCounterVec("tempdb_commands_total",
+		"Commands run by the store, by command.", "command")
+	commandDuration = metrics.NewHistogramVec("tempdb_command_duration_seconds",
+		"Time commands took to run, not counting time spent blocked, by command.", "command", metrics.DefBuckets)
+	walBytesWritten = metrics.NewCounter("tempdb_wal_bytes_written_total",
+		"Bytes written to the WAL.")
+	walFsyncDuration = metrics.NewHistogram("tempdb_wal_fsync_duration_seconds",
+		"Time taken to fsync the WAL.", metrics.DefBuckets)
+	snapshotDuration = metrics.NewHistogram("tempdb_snapshot_duration_seconds",
+		"Time taken to write a snapshot.", []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 120})
+	snapshotFailures = metrics.NewCounter("tempdb_snapshot_failures_total",
+		"Snapshots that could not be written.")
+)
+
+// observeCommand counts a command run by the store and how long it took.
+// Unknown commands are not counted, so clients can not add labels at will.
+func observeCommand(name string, elapsed time.Duration) {
+	cmd, exists := LookupCommand(name)
+	if !exists {
+		return
+	}
+	label := strings.ToLower(cmd.Name)
+	commandCalls.Inc(label)
+	commandDuration.Observe(label, elapsed.Seconds())
+}
+
+var (
+	metricsOnce  sync.Once
+	metricsStore atomic.Pointer[Store] // the store the metrics read, the latest one opened
+
+	//key counts, gathered once per scrape for the gauges reading them
+	scrapedKeys, scrapedExpires atomic.Int64
+)
+
+// registerMetrics makes the store the one its metrics are read from, the
+// metrics being registered by the first store only
+func (s *Store) registerMetrics() {
+	metricsStore.Store(s)
+	metricsOnce.Do(func() {
+		metrics.OnScrape(func() {
+			keys, expires := metricsStore.Load().keyCounts()
+			scrapedKeys.Store(int64(keys))
+			scrapedExpires.Store(int64(expires))
+		})
+		metrics.NewGaugeFunc("tempdb_keys", "Keys in the keyspace.", func() float64 {
+			return float64(scrapedKeys.Load())
+		})
+		metrics.NewGaugeFunc("tempdb_keys_with_expiry", "Keys in the keyspace with a TTL.", func() float64 {
+			return float64(scrapedExpires.Load())
+		})
+		metrics.NewCounterFunc("tempdb_expired_keys_total", "Keys removed because their TTL passed.", func() float64 {
+			return float64(metricsStore.Load().stats.expiredKeys.Load())
+		})
+		//keys are never evicted, there is no memory limit, but dashboards expect the series
+		metrics.NewCounterFunc("tempdb_evicted_keys_total", "Keys evicted to free memory.", func() float64 {
+			return 0
+		})
+		metrics.NewCounterFunc("tempdb_keyspace_hits_total", "Keys read-only commands found.", func() float64 {
+			return float64(metricsStore.Load().stats.keyspaceHits.Load())
+		})
+		metrics.NewCounterFunc("tempdb_keyspace_misses_total", "Keys read-only commands did not find.", func() float64 {
+			return float64(metricsStore.Load().stats.keyspaceMisses.Load())
+		})
+	})
+}
+
+// keyCounts counts the live keys and those with a TTL, one segment at a time.
+// Unlike keyspaceStats it does not size the values, so it stays cheap enough
+// to run on every scrape.
+func (s *Store) keyCounts() (int, int) {
+	now := time.Now().Unix()
+	keys, expires := 0, 0
+	for _, seg := range s.segments {
+		seg.mutex.RLock()
+		for _, v := range seg.kv {
+			if v.expired(now) {
+				continue
+			}
+			keys++
+			if v.ExpireAt != 0 {
+				expires++
+			}
+		}
+		seg.mutex.RUnlock()
+	}
+	return keys, expires
+}
+
+// countingWriter counts the bytes written to the WAL
+type countingWriter struct {
+	w io.Writer
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	walBytesWritten.Add(float64(n))
+	return n, err
+}
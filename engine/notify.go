@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"tempDB/config"
+)
+
+// notifyClasses selects which keyspace notifications are published, parsed
+// from the redis style notify_keyspace_events setting
+type notifyClasses uint32
+
+const (
+	notifyKeyspace notifyClasses = 1 << iota // K: publish to __keyspace@0__:<key>
+	notifyKeyevent                           // E: publish to __keyevent@0__:<event>
+	notifyGeneric                            // g: del, expire, persist, rename_from, rename_to
+	notifyString                             // $: set, append, setrange, setbit
+	notifyExpired                            // x: a key was removed because it expired
+	notifyEvicted                            // e: a key was removed to free memory
+	notifyStream                             // t: xadd, xtrim, xgroup-*
+
+	notifyAll = notifyGeneric | notifyString | notifyExpired | notifyEvicted | notifyStream // A
+)
+
+// parseNotifyClasses parses a setting such as "KEA" or "Ex"
+func parseNotifyClasses(flags string) (notifyClasses, error) {
+	var classes notifyClasses
+	for _, flag := range flags {
+		switch flag {
+		case 'K':
+			classes |= notifyKeyspace
+		case 'E':
+			classes |= notifyKeyevent
+		case 'g':
+			classes |= notifyGeneric
+		case '$':
+			classes |= notifyString
+		case 'x':
+			classes |= notifyExpired
+		case 'e':
+			classes |= notifyEvicted
+		case 't':
+			classes |= notifyStream
+		case 'A':
+			classes |= notifyAll
+		default:
+			return 0, fmt.Errorf("invalid keyspace notification class '%c'", flag)
+		}
+	}
+	return classes, nil
+}
+
+// loadNotifyClasses reads the notification setting, disabling notifications if it is invalid
+func loadNotifyClasses(log *slog.Logger) notifyClasses {
+	classes, err := parseNotifyClasses(config.GetStoreConfig().NotifyKeyspaceEvents)
+	if err != nil {
+		log.Error("Keyspace notifications disabled", "err", err)
+		return 0
+	}
+	return classes
+}
+
+// notify publishes a keyspace event if its class is enabled
+func (s *Store) notify(class notifyClasses, event string, key string) {
+	if s.notifications&class == 0 {
+		return
+	}
+	if s.notifications&notifyKeyspace != 0 {
+		s.broker.Publish("__keyspace@0__:"+key, []byte(event))
+	}
+	if s.notifications&notifyKeyevent != 0 {
+		s.broker.Publish("__keyevent@0__:"+event, []byte(key))
+	}
+}
+
+// notifyWrite publishes the events of a write, described by its WAL record
+func (s *Store) notifyWrite(record WALRecord) {
+	switch record.Command {
+	case "SET":
+		s.notify(notifyString, "set", record.Key)
+		if record.ExpireAt != 0 {
+			s.notify(notifyGeneric, "expire", record.Key)
+		}
+	case "APPEND":
+		s.notify(notifyString, "append", record.Key)
+	case "SETRANGE":
+		s.notify(notifyString, "setrange", record.Key)
+	case "SETBIT":
+		s.notify(notifyString, "setbit", record.Key)
+	case "DEL":
+		s.notify(notifyGeneric, "del", record.Key)
+	case "EXPIRE":
+		if record.ExpireAt == 0 {
+			s.notify(notifyGeneric, "persist", record.Key)
+		} else {
+			s.notify(notifyGeneric, "expire", record.Key)
+		}
+	case "RENAME":
+		s.notify(notifyGeneric, "rename_from", record.Key)
+		s.notify(notifyGeneric, "rename_to", string(record.Value))
+	case "XADD":
+		s.notify(notifyStream, "xadd", record.Key)
+	case "XTRIM":
+		s.notify(notifyStream, "xtrim", record.Key)
+	case "XGROUP":
+		s.notify(notifyStream, "xgroup-"+strings.ToLower(record.Args[0]), record.Key)
+	}
+}
+
+// notifyExpired publishes the removal of an expired key
+func (s *Store) notifyExpired(key string) {
+	s.notify(notifyExpired, "expired", key)
+}
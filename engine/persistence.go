@@ -5,11 +5,14 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"sync"
 	"tempDB/config"
+	"tempDB/logging"
 	"time"
 )
 
@@ -20,6 +23,14 @@ type WALRecord struct {
 	Key       string
 	Value     []byte
 	ExpireAt  int64
+	Offset    int64       // Byte or bit offset of a SETRANGE/SETBIT delta
+	Batch     []WALRecord // Records of a BATCH, applied all together or not at all
+	Version   uint64      // Version of the key after the write, 0 in logs written before versions were persisted
+	Args      []string    // Arguments of a stream record
+	Stream    *Stream     // Stream stored by a SET record, when the value is not a string
+	Queue     *Queue      // Queue stored by a SET record, when the value is not a string
+	Lock      *Lock       // Lock stored by a SET record, when the value is not a string
+	Seq       uint64      // Position of the record in the log, 0 in logs written before positions were recorded
 }
 
 // PersistenceManager manages the WAL and snapshotting logic.
@@ -33,6 +44,10 @@ type PersistenceManager struct {
 	walFileMaxSize  int64
 	maxWALFiles     int
 	walDirectory    string
+	lastRotation    time.Time
+	rotations       int64
+	walSeq          uint64 // position of the last record written
+	log             *slog.Logger
 }
 
 // NewPersistenceManager creates a new PersistenceManager.
@@ -47,6 +62,7 @@ func NewPersistenceManager() (*PersistenceManager, error) {
 		walFileMaxSize: cfg.WALMaxSizeBytes,
 		maxWALFiles:    cfg.WALMaxFiles,
 		walDirectory:   cfg.WALDirectory,
+		log:            logging.For("persistence"),
 	}
 
 	//Create walDirectory if it doesn't exist
@@ -60,7 +76,7 @@ func NewPersistenceManager() (*PersistenceManager, error) {
 		return nil, err
 	}
 	pm.walFile = walFile
-	pm.walEncoder = gob.NewEncoder(walFile)
+	pm.walEncoder = gob.NewEncoder(countingWriter{walFile})
 
 	//Open snapshot
 	snapshotFile, err := os.OpenFile(cfg.SnapshotFilePath, os.O_RDWR|os.O_CREATE, 0644)
@@ -85,13 +101,13 @@ func (pm *PersistenceManager) Close() error {
 		if err := pm.walFile.Close(); err != nil {
 			return err
 		}
-		fmt.Println("Closed wal File")
+		pm.log.Info("Closed WAL file")
 	}
 	if pm.snapshotFile != nil {
 		if err := pm.snapshotFile.Close(); err != nil {
 			return err
 		}
-		fmt.Println("Closed Snapshot File")
+		pm.log.Info("Closed snapshot file")
 	}
 	return nil
 }
@@ -110,24 +126,23 @@ func (pm *PersistenceManager) RotateWAL() error {
 
 	// Check if rotation is needed
 	if fileInfo.Size() < pm.walFileMaxSize {
-		fmt.Printf("INFO: No Rotation : WAL file size: %d Bytes, max size: %dBytes\n", fileInfo.Size(), pm.walFileMaxSize)
+		pm.log.Debug("No WAL rotation needed", "size", fileInfo.Size(), "max_size", pm.walFileMaxSize)
 		return nil
 	}
 
-	fmt.Println("Preparing for Rotating WAL...")
+	pm.log.Info("Rotating WAL", "size", fileInfo.Size(), "max_size", pm.walFileMaxSize)
 
 	// Close current WAL file
 	if err := pm.walFile.Close(); err != nil {
 		return fmt.Errorf("failed to close current WAL: %w", err)
 	}
-	fmt.Println("Closed Current wal File")
 
 	// Generate new WAL filename with timestamp
 	timestamp := time.Now().Format("20060102-150405")
 	newWALPath := filepath.Join(pm.walDirectory, fmt.Sprintf("wal-%s.log", timestamp))
 
 	// Rename current WAL to archived name (with timestamp)
-	fmt.Println("New Wal", newWALPath)
+	pm.log.Info("Archiving WAL", "path", newWALPath)
 	if err := os.Rename(pm.currentWALFile, newWALPath); err != nil {
 		return fmt.Errorf("failed to rename WAL file: %w", err)
 	}
@@ -139,17 +154,50 @@ func (pm *PersistenceManager) RotateWAL() error {
 	}
 
 	pm.walFile = walFile
-	pm.walEncoder = gob.NewEncoder(walFile)
+	pm.walEncoder = gob.NewEncoder(countingWriter{walFile})
+	pm.lastRotation = time.Now()
+	pm.rotations++
 
 	// Cleanup old WAL files
-	fmt.Println("Cleaning up old WAL files...")
 	if err := pm.cleanupOldWALFiles(); err != nil {
-		fmt.Printf("Warning: failed to cleanup old WAL files: %v\n", err)
+		pm.log.Warn("Failed to clean up old WAL files", "err", err)
 	}
 
 	return nil
 }
 
+// startSyncing fsyncs the WAL every interval, so the writes of at most one
+// interval are lost if the machine goes down
+func (pm *PersistenceManager) startSyncing(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		for range ticker.C {
+			pm.mutex.Lock()
+			start := time.Now()
+			err := pm.walFile.Sync()
+			walFsyncDuration.Observe(time.Since(start).Seconds())
+			pm.mutex.Unlock()
+			if err != nil {
+				pm.log.Error("Failed to sync WAL", "err", err)
+			}
+		}
+	}()
+}
+
+// WALStats returns the size of the current WAL file, when it was last
+// rotated and how many times it was since startup
+func (pm *PersistenceManager) WALStats() (int64, time.Time, int64) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	var size int64
+	if fileInfo, err := pm.walFile.Stat(); err == nil {
+		size = fileInfo.Size()
+	}
+	return size, pm.lastRotation, pm.rotations
+}
+
 // CleanupOldWALFiles removes old WAL files exceeding maxWALFiles
 func (pm *PersistenceManager) cleanupOldWALFiles() error {
 	files, err := filepath.Glob(filepath.Join(pm.walDirectory, "wal-*.log"))
@@ -183,39 +231,95 @@ func (pm *PersistenceManager) WriteWALRecord(record WALRecord) error {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
+	pm.walSeq++
+	record.Seq = pm.walSeq
 	record.Timestamp = time.Now().Unix()
 	return pm.walEncoder.Encode(record)
 }
 
-// LoadSnapshot loads the database from the snapshot file.
-func (pm *PersistenceManager) LoadSnapshot() (map[string]KeyValue, error) {
-	data := make(map[string]KeyValue)
+// WALSeq returns the position of the last record written to the WAL
+func (pm *PersistenceManager) WALSeq() uint64 {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	return pm.walSeq
+}
+
+// WriteWALBatch writes records produced by a single command or transaction.
+// Several records are wrapped into one BATCH record, so a crash can never
+// leave only part of them in the log.
+func (pm *PersistenceManager) WriteWALBatch(records []WALRecord) error {
+	if len(records) == 1 {
+		return pm.WriteWALRecord(records[0])
+	}
+	return pm.WriteWALRecord(WALRecord{Command: "BATCH", Batch: records})
+}
+
+// snapshotFormat is the layout version written by SaveSnapshot. Snapshots
+// from before it are a bare key-value map and are still read.
+const snapshotFormat = 1
+
+// snapshotFile is the content of the snapshot file.
+type snapshotFile struct {
+	Format       int
+	VersionClock uint64 // Highest key version handed out, so versions never repeat after a restart
+	WALSeq       uint64 // Position of the last WAL record the data holds, the records up to it are not replayed
+	Data         map[string]KeyValue
+}
+
+// LoadSnapshot loads the database, its version clock and the position of
+// the last WAL record it holds from the snapshot file.
+func (pm *PersistenceManager) LoadSnapshot() (map[string]KeyValue, uint64, uint64, error) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
 	// Reset the file pointer to the beginning of the file
 	_, err := pm.snapshotFile.Seek(0, 0)
 	if err != nil {
-		return nil, fmt.Errorf("failed to seek snapshot file: %w", err)
+		return nil, 0, 0, fmt.Errorf("failed to seek snapshot file: %w", err)
+	}
+
+	content, err := io.ReadAll(pm.snapshotFile)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to read snapshot file: %w", err)
+	}
+	if len(content) == 0 {
+		return make(map[string]KeyValue), 0, 0, nil
+	}
+
+	var snapshot snapshotFile
+	if err := json.Unmarshal(content, &snapshot); err == nil && snapshot.Format >= snapshotFormat {
+		if snapshot.Data == nil {
+			snapshot.Data = make(map[string]KeyValue)
+		}
+		return snapshot.Data, snapshot.VersionClock, snapshot.WALSeq, nil
 	}
 
-	decoder := json.NewDecoder(pm.snapshotFile)
-	err = decoder.Decode(&data)
-	if err != nil && err.Error() != "EOF" {
-		fmt.Println("Error decoding snapshot:", err)
-		return make(map[string]KeyValue), nil // Return empty map, but don't return the error
+	//older snapshots hold only the data
+	data := make(map[string]KeyValue)
+	if err := json.Unmarshal(content, &data); err != nil {
+		pm.log.Error("Failed to decode snapshot", "err", err)
+		return make(map[string]KeyValue), 0, 0, nil // Return empty map, but don't return the error
 	}
 
-	return data, nil
+	return data, 0, 0, nil
 }
 
-// SaveSnapshot saves the database to the snapshot file.
-func (pm *PersistenceManager) SaveSnapshot(data map[string]KeyValue) error {
+// SaveSnapshot saves the database, its version clock and the position of the
+// last WAL record it holds to the snapshot file.
+func (pm *PersistenceManager) SaveSnapshot(data map[string]KeyValue, versionClock uint64, walSeq uint64) (err error) {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
+	start := time.Now()
+	defer func() {
+		snapshotDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			snapshotFailures.Inc()
+		}
+	}()
+
 	// Reset the file pointer to the beginning of the file
-	_, err := pm.snapshotFile.Seek(0, 0)
+	_, err = pm.snapshotFile.Seek(0, 0)
 	if err != nil {
 		return fmt.Errorf("failed to seek snapshot file: %w", err)
 	}
@@ -227,7 +331,7 @@ func (pm *PersistenceManager) SaveSnapshot(data map[string]KeyValue) error {
 	}
 
 	encoder := json.NewEncoder(pm.snapshotFile)
-	err = encoder.Encode(data)
+	err = encoder.Encode(snapshotFile{Format: snapshotFormat, VersionClock: versionClock, WALSeq: walSeq, Data: data})
 	if err != nil {
 		return fmt.Errorf("failed to encode snapshot data: %w", err)
 	}
@@ -235,8 +339,10 @@ func (pm *PersistenceManager) SaveSnapshot(data map[string]KeyValue) error {
 	return nil
 }
 
-// ReplayWAL replays the WAL log to restore the database.
-func (pm *PersistenceManager) ReplayWAL(apply func(record WALRecord) error) error {
+// ReplayWAL replays the WAL log to restore the database. Records up to
+// afterSeq are already in the snapshot and skipped, as replaying them again
+// would apply writes such as APPEND or XADD twice.
+func (pm *PersistenceManager) ReplayWAL(afterSeq uint64, apply func(record WALRecord) error) error {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
@@ -248,6 +354,7 @@ func (pm *PersistenceManager) ReplayWAL(apply func(record WALRecord) error) erro
 
 	reader := bufio.NewReader(pm.walFile)
 	decoder := gob.NewDecoder(reader)
+	pm.walSeq = afterSeq
 
 	for {
 		var record WALRecord
@@ -259,6 +366,14 @@ func (pm *PersistenceManager) ReplayWAL(apply func(record WALRecord) error) erro
 			return fmt.Errorf("failed to decode WAL record: %w", err)
 		}
 
+		//records from before positions were recorded are always applied
+		if record.Seq != 0 && record.Seq <= afterSeq {
+			continue
+		}
+		if record.Seq > pm.walSeq {
+			pm.walSeq = record.Seq
+		}
+
 		err = apply(record)
 		if err != nil {
 			return fmt.Errorf("failed to apply WAL record: %w", err)
@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"tempDB/config"
+	"tempDB/utils"
+	"testing"
+	"time"
+)
+
+var testDir string
+
+// TestMain points the config at a temporary directory, so the WAL and the
+// snapshot written by the tests never touch the ones of a real server
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tempdb-engine")
+	if err != nil {
+		panic(err)
+	}
+	testDir = dir
+
+	cfg := fmt.Sprintf(`store:
+  segments_per_cpu: 1
+  cleanup_interval_seconds: 3600
+  wal_file_path: %s
+  snapshot_file_path: %s
+  wal_flush_interval_seconds: 3600
+  snapshot_interval_seconds: 3600
+  wal_directory: %s
+  lua_time_limit_ms: 100
+log:
+  level: error
+`, filepath.Join(dir, "wal.log"), filepath.Join(dir, "snapshot.db"), filepath.Join(dir, "wal"))
+	configPath := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(configPath, []byte(cfg), 0644); err != nil {
+		panic(err)
+	}
+	os.Setenv("CONFIG_PATH", configPath)
+	config.GetConfig()
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestStore opens a store on an empty WAL and snapshot
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	cfg := config.GetStoreConfig()
+	for _, path := range []string{cfg.WALFilePath, cfg.SnapshotFilePath, cfg.WALDirectory} {
+		if err := os.RemoveAll(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := NewStore()
+	t.Cleanup(func() { db.persistenceManager.Close() })
+	return &db
+}
+
+// restart closes the files of a store and opens a new one on them, as a
+// server restart would
+func restart(t *testing.T, db *Store) *Store {
+	t.Helper()
+	db.persistenceManager.Close()
+	restarted := NewStore()
+	t.Cleanup(func() { restarted.persistenceManager.Close() })
+	return &restarted
+}
+
+// run runs a command and returns its reply
+func run(t *testing.T, db *Store, args ...string) string {
+	t.Helper()
+	response, _, err := db.CommandHandler(utils.Request{Command: strings.ToUpper(args[0]), Params: args[1:]}, nil, nil)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return string(response)
+}
+
+func TestSnapshotThenReplay(t *testing.T) {
+	tests := []struct {
+		name   string
+		before [][]string // run before the snapshot
+		after  [][]string // run after the snapshot, before the restart
+		check  []string
+		want   string
+	}{
+		{
+			name:   "xadd",
+			before: [][]string{{"XADD", "s", "1-1", "f", "v"}},
+			check:  []string{"XLEN", "s"},
+			want:   ":1\r\n",
+		},
+		{
+			name:   "xadd after the snapshot",
+			before: [][]string{{"XADD", "s", "1-1", "f", "v"}},
+			after:  [][]string{{"XADD", "s", "1-2", "f", "v"}},
+			check:  []string{"XLEN", "s"},
+			want:   ":2\r\n",
+		},
+		{
+			name: "xtrim",
+			before: [][]string{
+				{"XADD", "s", "1-1", "f", "v"},
+				{"XADD", "s", "1-2", "f", "v"},
+				{"XADD", "s", "1-3", "f", "v"},
+				{"XTRIM", "s", "MAXLEN", "2"},
+			},
+			after: [][]string{{"XTRIM", "s", "MAXLEN", "1"}},
+			check: []string{"XLEN", "s"},
+			want:  ":1\r\n",
+		},
+		{
+			name:   "qpush",
+			before: [][]string{{"QPUSH", "q", "a"}},
+			check:  []string{"QSTATS", "q"},
+			want:   "*10\r\n+ready\r\n:1\r\n+delayed\r\n:0\r\n+leased\r\n:0\r\n+dead\r\n:0\r\n+pushed\r\n:1\r\n",
+		},
+		{
+			name:   "qpush after the snapshot",
+			before: [][]string{{"QPUSH", "q", "a"}},
+			after:  [][]string{{"QPUSH", "q", "b"}, {"QPOP", "q"}},
+			check:  []string{"QSTATS", "q"},
+			want:   "*10\r\n+ready\r\n:1\r\n+delayed\r\n:0\r\n+leased\r\n:1\r\n+dead\r\n:0\r\n+pushed\r\n:2\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestStore(t)
+			for _, cmd := range tt.before {
+				run(t, db, cmd...)
+			}
+			if err := db.createSnapshot(); err != nil {
+				t.Fatal(err)
+			}
+			for _, cmd := range tt.after {
+				run(t, db, cmd...)
+			}
+
+			db = restart(t, db)
+			if got := run(t, db, tt.check...); got != tt.want {
+				t.Errorf("%v after restart = %q, want %q", tt.check, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplayWriteToExpiredString(t *testing.T) {
+	db := newTestStore(t)
+	for _, key := range []string{"a", "r", "b"} {
+		run(t, db, "SET", key, "old", "EX", "1")
+	}
+	run(t, db, "XADD", "s", "1-1", "f", "old")
+	run(t, db, "EXPIRE", "s", "1")
+	run(t, db, "QPUSH", "q", "old")
+	run(t, db, "EXPIRE", "q", "1")
+	//the keys expire, but the cleanup loop has not removed them
+	time.Sleep(2 * time.Second)
+
+	run(t, db, "APPEND", "a", "new")
+	run(t, db, "SETRANGE", "r", "1", "new")
+	run(t, db, "SETBIT", "b", "1", "1")
+	run(t, db, "XADD", "s", "1-1", "f", "new")
+	run(t, db, "QPUSH", "q", "new")
+
+	db = restart(t, db)
+	tests := []struct {
+		check []string
+		want  string
+	}{
+		{[]string{"GET", "a"}, "+new\r\n"},
+		{[]string{"GET", "r"}, "+\x00new\r\n"},
+		{[]string{"GET", "b"}, "+@\r\n"},
+		{[]string{"XLEN", "s"}, ":1\r\n"},
+		{[]string{"TTL", "s"}, ":-1\r\n"},
+		{[]string{"QSTATS", "q"}, "*10\r\n+ready\r\n:1\r\n+delayed\r\n:0\r\n+leased\r\n:0\r\n+dead\r\n:0\r\n+pushed\r\n:1\r\n"},
+		{[]string{"TTL", "q"}, ":-1\r\n"},
+	}
+	for _, tt := range tests {
+		if got := run(t, db, tt.check...); got != tt.want {
+			t.Errorf("%v after restart = %q, want %q", tt.check, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Handles the parameters for PUBLISH command
+func (c *Context) publish(params []string) ([]byte, error) {
+	//CHANNEL MESSAGE
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR PUBLISH command requires channel and message\r\n")
+	}
+
+	receivers := c.db.broker.Publish(params[0], []byte(params[1]))
+	return []byte(fmt.Sprintf(":%d\r\n", receivers)), nil
+}
+
+// Handles the parameters for PUBSUB command
+func (c *Context) pubSub(params []string) ([]byte, error) {
+	//CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR PUBSUB command requires a subcommand\r\n")
+	}
+
+	switch strings.ToUpper(params[0]) {
+	case "CHANNELS":
+		if len(params) > 2 {
+			return []byte(""), errors.New("-ERR wrong number of arguments for 'pubsub|channels' command\r\n")
+		}
+		pattern := ""
+		if len(params) == 2 {
+			pattern = params[1]
+		}
+		channels := c.db.broker.ActiveChannels(pattern)
+		response := []byte(fmt.Sprintf("*%d\r\n", len(channels)))
+		for _, channel := range channels {
+			response = append(response, []byte(fmt.Sprintf("+%s\r\n", channel))...)
+		}
+		return response, nil
+	case "NUMSUB":
+		response := []byte(fmt.Sprintf("*%d\r\n", 2*(len(params)-1)))
+		for _, channel := range params[1:] {
+			response = append(response, []byte(fmt.Sprintf("+%s\r\n:%d\r\n", channel, c.db.broker.NumSub(channel)))...)
+		}
+		return response, nil
+	case "NUMPAT":
+		if len(params) != 1 {
+			return []byte(""), errors.New("-ERR wrong number of arguments for 'pubsub|numpat' command\r\n")
+		}
+		return []byte(fmt.Sprintf(":%d\r\n", c.db.broker.NumPat())), nil
+	}
+	return []byte(""), fmt.Errorf("-ERR unknown subcommand '%s'. Try PUBSUB CHANNELS, NUMSUB or NUMPAT.\r\n", params[0])
+}
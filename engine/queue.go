@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"sort"
+	"strconv"
+)
+
+// QueueMessage is a message of a work queue. A message is ready once its
+// VisibleAt time has passed; leasing it hides it again until the lease ends.
+type QueueMessage struct {
+	ID          uint64
+	Payload     string
+	VisibleAt   int64 // Unix milliseconds it becomes visible, or its lease ends
+	Attempts    int64 // number of times it was leased
+	MaxAttempts int64 // attempts before it is dead-lettered, 0 for no limit
+	Leased      bool
+}
+
+// Queue is a work queue delivering each message at least once: a consumer
+// leases messages for a visibility timeout and acknowledges them, and
+// messages whose lease ends are delivered again, or dead-lettered once they
+// have used all their attempts.
+//
+// Lease expiry only depends on the time, so it is never logged: commands
+// expire the leases at the time their WAL record carries, and replaying the
+// record repeats it. The cleanup loop expires them in between.
+type Queue struct {
+	NextID   uint64          // ID of the last message pushed
+	Messages []*QueueMessage // live messages in ID order
+	Dead     []*QueueMessage // dead-lettered messages in the order they died
+}
+
+func newQueue() *Queue {
+	return &Queue{}
+}
+
+// clone deep copies the queue, which is otherwise modified in place
+func (q *Queue) clone() *Queue {
+	if q == nil {
+		return nil
+	}
+	copied := &Queue{NextID: q.NextID}
+	for _, msg := range q.Messages {
+		m := *msg
+		copied.Messages = append(copied.Messages, &m)
+	}
+	for _, msg := range q.Dead {
+		m := *msg
+		copied.Dead = append(copied.Dead, &m)
+	}
+	return copied
+}
+
+// find returns the live message with the given ID
+func (q *Queue) find(id uint64) (int, *QueueMessage) {
+	i := sort.Search(len(q.Messages), func(i int) bool { return q.Messages[i].ID >= id })
+	if i < len(q.Messages) && q.Messages[i].ID == id {
+		return i, q.Messages[i]
+	}
+	return i, nil
+}
+
+// remove drops the live message at index i
+func (q *Queue) remove(i int) {
+	q.Messages = append(q.Messages[:i], q.Messages[i+1:]...)
+}
+
+// exhausted reports whether a message has no attempts left
+func (m *QueueMessage) exhausted() bool {
+	return m.MaxAttempts > 0 && m.Attempts >= m.MaxAttempts
+}
+
+// expireLeases ends the leases that ran out by now, making their messages
+// ready again or dead-lettering them, and reports whether any did
+func (q *Queue) expireLeases(now int64) bool {
+	changed := false
+	live := q.Messages[:0]
+	for _, msg := range q.Messages {
+		if msg.Leased && msg.VisibleAt <= now {
+			changed = true
+			msg.Leased = false
+			if msg.exhausted() {
+				q.Dead = append(q.Dead, msg)
+				continue
+			}
+		}
+		live = append(live, msg)
+	}
+	//clear the tail so dead-lettered messages are not kept alive twice
+	for i := len(live); i < len(q.Messages); i++ {
+		q.Messages[i] = nil
+	}
+	q.Messages = live
+	return changed
+}
+
+// hasExpiredLeases reports whether a lease ran out by now
+func (q *Queue) hasExpiredLeases(now int64) bool {
+	for _, msg := range q.Messages {
+		if msg.Leased && msg.VisibleAt <= now {
+			return true
+		}
+	}
+	return false
+}
+
+// at returns the queue as it is at now, a copy with the expired leases ended
+// if there are any, for commands that may not modify it
+func (q *Queue) at(now int64) *Queue {
+	if !q.hasExpiredLeases(now) {
+		return q
+	}
+	view := q.clone()
+	view.expireLeases(now)
+	return view
+}
+
+// ready returns up to count messages that can be leased at now, oldest first.
+// A count below 1 means no limit. Expired leases must have been ended.
+func (q *Queue) ready(now int64, count int) []*QueueMessage {
+	messages := []*QueueMessage{}
+	for _, msg := range q.Messages {
+		if count > 0 && len(messages) == count {
+			break
+		}
+		if !msg.Leased && msg.VisibleAt <= now {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// apply applies a queue WAL record, whose Offset holds the time it was
+// written at. Like Stream.apply, commands build the record of a change and
+// apply it, so replaying the log repeats exactly what they did.
+func (q *Queue) apply(record WALRecord) {
+	now := record.Offset
+	q.expireLeases(now)
+
+	//the first argument of most records is a duration in milliseconds
+	duration := func() int64 {
+		ms, _ := strconv.ParseInt(record.Args[0], 10, 64)
+		return ms
+	}
+	ids := func(args []string) []uint64 {
+		parsed := make([]uint64, 0, len(args))
+		for _, arg := range args {
+			id, _ := strconv.ParseUint(arg, 10, 64)
+			parsed = append(parsed, id)
+		}
+		return parsed
+	}
+
+	switch record.Command {
+	case "QPUSH":
+		//DELAY MAXATTEMPTS PAYLOAD [PAYLOAD ...]
+		maxAttempts, _ := strconv.ParseInt(record.Args[1], 10, 64)
+		for _, payload := range record.Args[2:] {
+			q.NextID++
+			q.Messages = append(q.Messages, &QueueMessage{
+				ID:          q.NextID,
+				Payload:     payload,
+				VisibleAt:   now + duration(),
+				MaxAttempts: maxAttempts,
+			})
+		}
+	case "QPOP":
+		//VISIBILITY ID [ID ...]
+		for _, id := range ids(record.Args[1:]) {
+			if _, msg := q.find(id); msg != nil {
+				msg.Leased = true
+				msg.Attempts++
+				msg.VisibleAt = now + duration()
+			}
+		}
+	case "QACK":
+		//ID [ID ...]
+		for _, id := range ids(record.Args) {
+			if i, msg := q.find(id); msg != nil {
+				q.remove(i)
+			}
+		}
+	case "QNACK":
+		//DELAY ID [ID ...]
+		for _, id := range ids(record.Args[1:]) {
+			i, msg := q.find(id)
+			if msg == nil {
+				continue
+			}
+			msg.Leased = false
+			if msg.exhausted() {
+				q.remove(i)
+				q.Dead = append(q.Dead, msg)
+				continue
+			}
+			msg.VisibleAt = now + duration()
+		}
+	case "QEXTEND":
+		//MS ID [ID ...]
+		for _, id := range ids(record.Args[1:]) {
+			if _, msg := q.find(id); msg != nil {
+				msg.VisibleAt = now + duration()
+			}
+		}
+	case "QREDRIVE":
+		//COUNT, the number of dead messages to make ready again
+		n := int(duration())
+		if n > len(q.Dead) {
+			n = len(q.Dead)
+		}
+		for _, msg := range q.Dead[:n] {
+			msg.Attempts = 0
+			msg.VisibleAt = now
+			q.Messages = append(q.Messages, msg)
+		}
+		q.Dead = q.Dead[n:]
+		sort.Slice(q.Messages, func(i, j int) bool { return q.Messages[i].ID < q.Messages[j].ID })
+	}
+}
+
+// isQueueRecord reports whether a WAL record is applied through Queue.apply
+func isQueueRecord(command string) bool {
+	switch command {
+	case "QPUSH", "QPOP", "QACK", "QNACK", "QEXTEND", "QREDRIVE":
+		return true
+	}
+	return false
+}
+
+// lookupQueue returns the queue stored under a key, nil if the key does not exist
+func (seg *segment) lookupQueue(key string) (KeyValue, *Queue, error) {
+	kv, exists := seg.lookup(key)
+	if !exists {
+		return kv, nil, nil
+	}
+	if kv.Queue == nil {
+		return kv, nil, errWrongType
+	}
+	return kv, kv.Queue, nil
+}
@@ -0,0 +1,382 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"tempDB/config"
+	"time"
+)
+
+// putQueue stores a queue whose record was just applied to it, and logs it
+func (c *Context) putQueue(seg *segment, key string, kv KeyValue, record WALRecord) {
+	record.Key = key
+	record.Version = c.put(seg, key, kv)
+	c.log(record)
+}
+
+// messagesReply encodes messages as their ID, number of attempts and payload
+func messagesReply(messages []*QueueMessage) []byte {
+	response := []byte(fmt.Sprintf("*%d\r\n", len(messages)))
+	for _, msg := range messages {
+		response = append(response, []byte(fmt.Sprintf("*3\r\n:%d\r\n:%d\r\n+%s\r\n", msg.ID, msg.Attempts, msg.Payload))...)
+	}
+	return response
+}
+
+// parseMs parses a duration or count option, which must not be negative
+func parseMs(arg string) (int64, error) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || ms < 0 {
+		return 0, errors.New("-ERR value is not an integer or out of range\r\n")
+	}
+	return ms, nil
+}
+
+// parseMessageIDs parses the message IDs given to a queue command
+func parseMessageIDs(args []string) ([]uint64, error) {
+	ids := make([]uint64, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return nil, errors.New("-ERR invalid message ID\r\n")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Handles the parameters for QPUSH command
+func (c *Context) qPush(params []string) ([]byte, error) {
+	//KEY [DELAY ms] [MAXATTEMPTS n] PAYLOAD [PAYLOAD ...]
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'qpush' command\r\n")
+	}
+
+	key := params[0]
+	delay, maxAttempts := int64(0), config.GetQueueConfig().MaxAttempts
+
+	i := 1
+options:
+	for i+1 < len(params) {
+		switch strings.ToUpper(params[i]) {
+		case "DELAY":
+			ms, err := parseMs(params[i+1])
+			if err != nil {
+				return []byte(""), err
+			}
+			delay = ms
+		case "MAXATTEMPTS":
+			n, err := parseMs(params[i+1])
+			if err != nil {
+				return []byte(""), err
+			}
+			maxAttempts = n
+		default:
+			break options
+		}
+		i += 2
+	}
+	if i >= len(params) {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'qpush' command\r\n")
+	}
+
+	seg := c.db.getSegment(key)
+	kv, q, err := seg.lookupQueue(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if q == nil {
+		q = newQueue()
+		kv = KeyValue{Queue: q}
+		//logged whole, as the log may still hold an expired queue under
+		//the key that replaying the push alone would add to
+		c.Put(key, kv)
+	}
+
+	first := q.NextID + 1
+	args := append([]string{strconv.FormatInt(delay, 10), strconv.FormatInt(maxAttempts, 10)}, params[i:]...)
+	record := WALRecord{Command: "QPUSH", Args: args, Offset: nowMs()}
+	q.apply(record)
+	c.putQueue(seg, key, kv, record)
+
+	response := []byte(fmt.Sprintf("*%d\r\n", len(params)-i))
+	for id := first; id <= q.NextID; id++ {
+		response = append(response, []byte(fmt.Sprintf(":%d\r\n", id))...)
+	}
+	return response, nil
+}
+
+// Handles the parameters for QPOP command
+func (c *Context) qPop(params []string) ([]byte, error) {
+	//KEY [COUNT count] [VISIBILITY ms] [BLOCK ms]
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR QPOP command requires a key\r\n")
+	}
+
+	count, visibility := int64(1), config.GetQueueConfig().VisibilityTimeoutMs
+	blocking, block := false, time.Duration(0)
+	for i := 1; i < len(params); i += 2 {
+		if i+1 >= len(params) {
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+		n, err := parseMs(params[i+1])
+		if err != nil {
+			return []byte(""), err
+		}
+		switch strings.ToUpper(params[i]) {
+		case "COUNT":
+			if n < 1 {
+				return []byte(""), errors.New("-ERR COUNT must be > 0\r\n")
+			}
+			count = n
+		case "VISIBILITY":
+			if n < 1 {
+				return []byte(""), errors.New("-ERR VISIBILITY must be > 0\r\n")
+			}
+			visibility = n
+		case "BLOCK":
+			blocking, block = true, time.Duration(n)*time.Millisecond
+		default:
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+	kv, q, err := seg.lookupQueue(key)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	var messages []*QueueMessage
+	now := nowMs()
+	if q != nil {
+		q.expireLeases(now)
+		messages = q.ready(now, int(count))
+	}
+
+	if len(messages) == 0 {
+		if blocking && c.canBlock {
+			return []byte(""), &blockedError{keys: []string{key}, timeout: block, retry: params}
+		}
+		return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+	}
+
+	args := []string{strconv.FormatInt(visibility, 10)}
+	for _, msg := range messages {
+		args = append(args, strconv.FormatUint(msg.ID, 10))
+	}
+	record := WALRecord{Command: "QPOP", Args: args, Offset: now}
+	q.apply(record)
+	c.putQueue(seg, key, kv, record)
+
+	return messagesReply(messages), nil
+}
+
+// leased applies a record built from the messages of the queue that are
+// currently leased, among the given IDs, and returns how many there were.
+// Messages whose lease ran out can no longer be acknowledged, as they may
+// already have been delivered again.
+func (c *Context) leased(key string, ids []uint64, command string, args []string) (int, error) {
+	seg := c.db.getSegment(key)
+	kv, q, err := seg.lookupQueue(key)
+	if err != nil || q == nil {
+		return 0, err
+	}
+
+	now := nowMs()
+	q.expireLeases(now)
+	n := 0
+	for _, id := range ids {
+		if _, msg := q.find(id); msg != nil && msg.Leased {
+			args = append(args, strconv.FormatUint(id, 10))
+			n++
+		}
+	}
+	if n > 0 {
+		record := WALRecord{Command: command, Args: args, Offset: now}
+		q.apply(record)
+		c.putQueue(seg, key, kv, record)
+	}
+	return n, nil
+}
+
+// Handles the parameters for QACK command
+func (c *Context) qAck(params []string) ([]byte, error) {
+	//KEY ID [ID ...]
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'qack' command\r\n")
+	}
+
+	ids, err := parseMessageIDs(params[1:])
+	if err != nil {
+		return []byte(""), err
+	}
+	n, err := c.leased(params[0], ids, "QACK", []string{})
+	if err != nil {
+		return []byte(""), err
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", n)), nil
+}
+
+// Handles the parameters for QNACK command
+func (c *Context) qNack(params []string) ([]byte, error) {
+	//KEY [DELAY ms] ID [ID ...]
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'qnack' command\r\n")
+	}
+
+	delay, rest := int64(0), params[1:]
+	if strings.ToUpper(rest[0]) == "DELAY" {
+		if len(rest) < 3 {
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+		ms, err := parseMs(rest[1])
+		if err != nil {
+			return []byte(""), err
+		}
+		delay, rest = ms, rest[2:]
+	}
+
+	ids, err := parseMessageIDs(rest)
+	if err != nil {
+		return []byte(""), err
+	}
+	n, err := c.leased(params[0], ids, "QNACK", []string{strconv.FormatInt(delay, 10)})
+	if err != nil {
+		return []byte(""), err
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", n)), nil
+}
+
+// Handles the parameters for QEXTEND command
+func (c *Context) qExtend(params []string) ([]byte, error) {
+	//KEY MS ID [ID ...]
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'qextend' command\r\n")
+	}
+
+	ms, err := parseMs(params[1])
+	if err != nil || ms < 1 {
+		return []byte(""), errors.New("-ERR invalid lease time\r\n")
+	}
+	ids, err := parseMessageIDs(params[2:])
+	if err != nil {
+		return []byte(""), err
+	}
+	n, err := c.leased(params[0], ids, "QEXTEND", []string{strconv.FormatInt(ms, 10)})
+	if err != nil {
+		return []byte(""), err
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", n)), nil
+}
+
+// Handles the parameters for QSTATS command
+func (c *Context) qStats(params []string) ([]byte, error) {
+	//KEY
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR QSTATS command requires a key\r\n")
+	}
+
+	_, q, err := c.db.getSegment(params[0]).lookupQueue(params[0])
+	if err != nil {
+		return []byte(""), err
+	}
+
+	var ready, delayed, leased, dead, pushed int
+	if q != nil {
+		now := nowMs()
+		q = q.at(now)
+		for _, msg := range q.Messages {
+			switch {
+			case msg.Leased:
+				leased++
+			case msg.VisibleAt > now:
+				delayed++
+			default:
+				ready++
+			}
+		}
+		dead, pushed = len(q.Dead), int(q.NextID)
+	}
+
+	return []byte(fmt.Sprintf("*10\r\n+ready\r\n:%d\r\n+delayed\r\n:%d\r\n+leased\r\n:%d\r\n+dead\r\n:%d\r\n+pushed\r\n:%d\r\n",
+		ready, delayed, leased, dead, pushed)), nil
+}
+
+// parseCount parses an optional trailing COUNT n, with def as the default
+func parseCount(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	if len(args) != 2 || strings.ToUpper(args[0]) != "COUNT" {
+		return 0, errors.New("-ERR syntax error\r\n")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 {
+		return 0, errors.New("-ERR COUNT must be > 0\r\n")
+	}
+	return n, nil
+}
+
+// Handles the parameters for QDEAD command
+func (c *Context) qDead(params []string) ([]byte, error) {
+	//KEY [COUNT count]
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR QDEAD command requires a key\r\n")
+	}
+	count, err := parseCount(params[1:], 10)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	_, q, err := c.db.getSegment(params[0]).lookupQueue(params[0])
+	if err != nil {
+		return []byte(""), err
+	}
+	if q == nil {
+		return []byte("*0\r\n"), nil
+	}
+
+	dead := q.at(nowMs()).Dead
+	if count < len(dead) {
+		dead = dead[:count]
+	}
+	return messagesReply(dead), nil
+}
+
+// Handles the parameters for QREDRIVE command
+func (c *Context) qRedrive(params []string) ([]byte, error) {
+	//KEY [COUNT count]
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR QREDRIVE command requires a key\r\n")
+	}
+	count, err := parseCount(params[1:], 0)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+	kv, q, err := seg.lookupQueue(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if q == nil {
+		return []byte(":0\r\n"), nil
+	}
+
+	now := nowMs()
+	q.expireLeases(now)
+	if count == 0 || count > len(q.Dead) {
+		count = len(q.Dead)
+	}
+	if count > 0 {
+		record := WALRecord{Command: "QREDRIVE", Args: []string{strconv.Itoa(count)}, Offset: now}
+		q.apply(record)
+		c.putQueue(seg, key, kv, record)
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", count)), nil
+}
@@ -0,0 +1,347 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CommandFlags describe how a command behaves
+type CommandFlags uint32
+
+const (
+	FlagWrite      CommandFlags = 1 << iota // may modify its keys, which are write-locked
+	FlagReadOnly                            // only reads its keys, which are read-locked
+	FlagKeyspace                            // works on the whole keyspace rather than on given keys
+	FlagConnection                          // handled by the server for the connection, not by the engine
+	FlagNoScript                            // may not be called from a script
+	FlagAdmin                               // administrative, dangerous to hand out to ordinary clients
+	FlagBlocking                            // may block the connection until some condition is met
+	FlagDangerous                           // may lose data or expose other clients, denied by careful ACLs
+)
+
+// flagNames are the flag names shown by COMMAND INFO, in display order
+var flagNames = []struct {
+	flag CommandFlags
+	name string
+}{
+	{FlagWrite, "write"},
+	{FlagReadOnly, "readonly"},
+	{FlagKeyspace, "keyspace"},
+	{FlagConnection, "connection"},
+	{FlagNoScript, "noscript"},
+	{FlagAdmin, "admin"},
+	{FlagBlocking, "blocking"},
+	{FlagDangerous, "dangerous"},
+}
+
+// flagCategories are the ACL categories given by the flags, besides the group
+var flagCategories = []struct {
+	flag     CommandFlags
+	category string
+}{
+	{FlagReadOnly, "read"},
+	{FlagWrite, "write"},
+	{FlagAdmin, "admin"},
+	{FlagDangerous, "dangerous"},
+	{FlagBlocking, "blocking"},
+}
+
+// CommandFunc runs a command once the segments of its keys are locked.
+// args holds the arguments following the command name.
+type CommandFunc func(ctx *Context, args []string) ([]byte, error)
+
+// Command describes a command: how many arguments it takes, where its keys
+// are and how it runs. Positions count the command name as 0, as in redis.
+type Command struct {
+	Name     string
+	Arity    int                          // argument count including the name, -N meaning at least N
+	FirstKey int                          // position of the first key, 0 if the command takes no keys
+	LastKey  int                          // position of the last key, negative counting back from the end
+	KeyStep  int                          // distance between two keys
+	Keys     func(args []string) []string // overrides the key positions when they depend on the arguments
+	Flags    CommandFlags
+	Handler  CommandFunc
+	Group    string // shown by COMMAND DOCS
+	Summary  string // shown by COMMAND DOCS
+}
+
+var (
+	registry      = make(map[string]*Command)
+	registryMutex = &sync.RWMutex{}
+)
+
+func init() {
+	for _, cmd := range builtinCommands {
+		if err := RegisterCommand(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterCommand adds a command to the engine. Go code shipped with the
+// server can use it (typically from an init function) to provide its own
+// commands, which are then validated, locked, dispatched and logged to the
+// WAL exactly like the built-in ones.
+func RegisterCommand(cmd Command) error {
+	if cmd.Name == "" || cmd.Arity == 0 {
+		return errors.New("command needs a name and an arity")
+	}
+	if cmd.Handler == nil && cmd.Flags&FlagConnection == 0 {
+		return fmt.Errorf("command %s has no handler", cmd.Name)
+	}
+	if cmd.Flags&FlagWrite != 0 && cmd.Flags&FlagReadOnly != 0 {
+		return fmt.Errorf("command %s can not be both write and readonly", cmd.Name)
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	cmd.Name = strings.ToUpper(cmd.Name)
+	if _, exists := registry[cmd.Name]; exists {
+		return fmt.Errorf("command %s is already registered", cmd.Name)
+	}
+	registry[cmd.Name] = &cmd
+	return nil
+}
+
+// LookupCommand returns the command registered under name, ignoring case
+func LookupCommand(name string) (*Command, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	cmd, exists := registry[strings.ToUpper(name)]
+	return cmd, exists
+}
+
+// CheckCommand validates a parsed command line against the registry,
+// rewriting the command name in place to its registered (upper case) form
+func CheckCommand(cmd []string) error {
+	if len(cmd) == 0 {
+		return errors.New("-ERR empty command\r\n")
+	}
+
+	info, exists := LookupCommand(cmd[0])
+	if !exists {
+		args := ""
+		for _, arg := range cmd[1:] {
+			args += fmt.Sprintf("'%s' ", arg)
+		}
+		return fmt.Errorf("-ERR unknown command '%s', with args beginning with: %s\r\n", cmd[0], args)
+	}
+	if !info.ValidArity(len(cmd)) {
+		return fmt.Errorf("-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(info.Name))
+	}
+
+	cmd[0] = info.Name
+	return nil
+}
+
+// commands returns every registered command sorted by name
+func commands() []*Command {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	all := make([]*Command, 0, len(registry))
+	for _, cmd := range registry {
+		all = append(all, cmd)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// ValidArity reports whether argc arguments (including the name) suit the command
+func (cmd *Command) ValidArity(argc int) bool {
+	if cmd.Arity > 0 {
+		return argc == cmd.Arity
+	}
+	return argc >= -cmd.Arity
+}
+
+// KeysOf returns the keys the command touches when called with args
+func (cmd *Command) KeysOf(args []string) []string {
+	if cmd.Keys != nil {
+		return cmd.Keys(args)
+	}
+	if cmd.FirstKey == 0 {
+		return nil
+	}
+
+	last := cmd.LastKey
+	if last < 0 {
+		last += len(args) + 1
+	}
+	step := cmd.KeyStep
+	if step < 1 {
+		step = 1
+	}
+
+	keys := []string{}
+	for pos := cmd.FirstKey; pos <= last && pos <= len(args); pos += step {
+		keys = append(keys, args[pos-1])
+	}
+	return keys
+}
+
+// Categories returns the ACL categories of the command: its group, and
+// read, write, admin, dangerous and blocking as its flags say
+func (cmd *Command) Categories() []string {
+	categories := []string{cmd.Group}
+	for _, fc := range flagCategories {
+		if cmd.Flags&fc.flag != 0 {
+			categories = append(categories, fc.category)
+		}
+	}
+	return categories
+}
+
+// Categories returns every ACL category some command belongs to, sorted
+func Categories() []string {
+	seen := make(map[string]bool)
+	categories := []string{}
+	for _, cmd := range commands() {
+		for _, category := range cmd.Categories() {
+			if !seen[category] {
+				seen[category] = true
+				categories = append(categories, category)
+			}
+		}
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// CommandsIn returns the names of the commands in an ACL category, sorted
+func CommandsIn(category string) []string {
+	names := []string{}
+	for _, cmd := range commands() {
+		for _, c := range cmd.Categories() {
+			if c == category {
+				names = append(names, cmd.Name)
+				break
+			}
+		}
+	}
+	return names
+}
+
+// access returns how the command needs its segments locked
+func (cmd *Command) access() lockAccess {
+	switch {
+	case cmd.Flags&FlagKeyspace != 0 && cmd.Flags&FlagWrite != 0:
+		return accessAll
+	case cmd.Flags&FlagKeyspace != 0:
+		return accessSelf
+	case cmd.Flags&FlagWrite != 0:
+		return accessWrite
+	case cmd.Flags&FlagReadOnly != 0:
+		return accessRead
+	}
+	return accessNone
+}
+
+// builtinCommands are the commands every server has
+var builtinCommands = []Command{
+	{Name: "PING", Group: "connection", Summary: "Test server connectivity", Arity: 1, Handler: (*Context).ping},
+	{Name: "AUTH", Group: "connection", Summary: "Authenticate the connection", Arity: -2, Flags: FlagConnection | FlagNoScript},
+	{Name: "INFO", Group: "server", Summary: "Report the state and statistics of the server", Arity: -1, Flags: FlagConnection | FlagNoScript | FlagDangerous},
+	{Name: "CLIENT", Group: "connection", Summary: "Inspect, name, kill and pause client connections", Arity: -2, Flags: FlagConnection | FlagNoScript},
+	{Name: "ACL", Group: "server", Summary: "Manage the users, their permissions and the log of denied attempts", Arity: -2, Flags: FlagConnection | FlagNoScript | FlagAdmin | FlagDangerous},
+	{Name: "SLOWLOG", Group: "server", Summary: "Read or reset the log of commands that ran for longer than a threshold", Arity: -2, Flags: FlagConnection | FlagNoScript | FlagAdmin | FlagDangerous},
+
+	//strings
+	{Name: "GET", Group: "string", Summary: "Get the value of a key", Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).get},
+	{Name: "SET", Group: "string", Summary: "Set the value of a key, optionally only if it does or does not exist, with an expiration", Arity: -3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).set},
+	{Name: "SETNX", Group: "string", Summary: "Set the value of a key only if it does not exist", Arity: 3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).setNX},
+	{Name: "GETSET", Group: "string", Summary: "Set the value of a key and return its old value", Arity: 3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).getSet},
+	{Name: "GETDEL", Group: "string", Summary: "Get the value of a key and delete it", Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).getDel},
+	{Name: "GETEX", Group: "string", Summary: "Get the value of a key and update its expiration", Arity: -2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).getEx},
+	{Name: "MGET", Group: "string", Summary: "Get the values of several keys", Arity: -2, FirstKey: 1, LastKey: -1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).mget},
+	{Name: "MSET", Group: "string", Summary: "Set several keys atomically", Arity: -3, FirstKey: 1, LastKey: -1, KeyStep: 2, Flags: FlagWrite, Handler: (*Context).mset},
+	{Name: "MSETNX", Group: "string", Summary: "Set several keys only if none of them exist", Arity: -3, FirstKey: 1, LastKey: -1, KeyStep: 2, Flags: FlagWrite, Handler: (*Context).msetNX},
+	{Name: "APPEND", Group: "string", Summary: "Append to the value of a key", Arity: 3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).appendValue},
+	{Name: "STRLEN", Group: "string", Summary: "Get the length of the value of a key", Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).strLen},
+	{Name: "GETRANGE", Group: "string", Summary: "Get a substring of the value of a key", Arity: 4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).getRange},
+	{Name: "SETRANGE", Group: "string", Summary: "Overwrite part of the value of a key", Arity: 4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).setRange},
+
+	{Name: "GETVER", Group: "string", Summary: "Get the value of a key along with its version", Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).getVersion},
+	{Name: "CAS", Group: "string", Summary: "Set the value of a key only if its version matches", Arity: -4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).compareAndSet},
+
+	//streams
+	{Name: "XADD", Group: "stream", Summary: "Append an entry to a stream", Arity: -5, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).xAdd},
+	{Name: "XLEN", Group: "stream", Summary: "Get the number of entries in a stream", Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).xLen},
+	{Name: "XRANGE", Group: "stream", Summary: "Get the entries of a stream within a range of IDs", Arity: -4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).xRange},
+	{Name: "XREVRANGE", Group: "stream", Summary: "Get the entries of a stream within a range of IDs, newest first", Arity: -4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).xRevRange},
+	{Name: "XTRIM", Group: "stream", Summary: "Remove the oldest entries of a stream", Arity: -4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).xTrim},
+	{Name: "XREAD", Group: "stream", Summary: "Read entries after the given IDs, optionally waiting for them", Arity: -4, Keys: xReadKeys, Flags: FlagReadOnly | FlagBlocking, Handler: (*Context).xRead},
+	{Name: "XREADGROUP", Group: "stream", Summary: "Read entries on behalf of a consumer of a group", Arity: -7, Keys: xReadGroupKeys, Flags: FlagWrite | FlagBlocking, Handler: (*Context).xReadGroup},
+	{Name: "XGROUP", Group: "stream", Summary: "Create, configure and destroy consumer groups and their consumers", Arity: -4, FirstKey: 2, LastKey: 2, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).xGroup},
+	{Name: "XACK", Group: "stream", Summary: "Acknowledge entries delivered to a consumer group", Arity: -4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).xAck},
+	{Name: "XPENDING", Group: "stream", Summary: "Inspect the entries pending in a consumer group", Arity: -3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).xPending},
+	{Name: "XCLAIM", Group: "stream", Summary: "Take over pending entries idle for long enough", Arity: -6, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).xClaim},
+	{Name: "XAUTOCLAIM", Group: "stream", Summary: "Scan the pending entries of a group and take over the idle ones", Arity: -6, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).xAutoClaim},
+
+	//queues
+	{Name: "QPUSH", Group: "queue", Summary: "Add messages to a work queue, optionally delayed", Arity: -3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).qPush},
+	{Name: "QPOP", Group: "queue", Summary: "Lease ready messages of a work queue for a visibility timeout", Arity: -2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite | FlagBlocking, Handler: (*Context).qPop},
+	{Name: "QACK", Group: "queue", Summary: "Acknowledge leased messages, removing them from the queue", Arity: -3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).qAck},
+	{Name: "QNACK", Group: "queue", Summary: "Give leased messages back to the queue, optionally delayed", Arity: -3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).qNack},
+	{Name: "QEXTEND", Group: "queue", Summary: "Extend the lease of leased messages", Arity: -4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).qExtend},
+	{Name: "QSTATS", Group: "queue", Summary: "Count the messages of a work queue by state", Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).qStats},
+	{Name: "QDEAD", Group: "queue", Summary: "List the dead-lettered messages of a work queue", Arity: -2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).qDead},
+	{Name: "QREDRIVE", Group: "queue", Summary: "Move dead-lettered messages back to the queue", Arity: -2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).qRedrive},
+
+	//locks
+	{Name: "LOCK", Group: "lock", Summary: "Acquire a lock for an owner, returning its fencing token", Arity: 4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).lock},
+	{Name: "UNLOCK", Group: "lock", Summary: "Release a lock held by the given owner", Arity: 3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).unlock},
+	{Name: "LOCKEXTEND", Group: "lock", Summary: "Extend the lease of a lock held by the given owner", Arity: 4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).lockExtend},
+	{Name: "LOCKINFO", Group: "lock", Summary: "Get the owner, fencing token and remaining lease of a lock", Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).lockInfo},
+
+	//bitmaps
+	{Name: "SETBIT", Group: "bitmap", Summary: "Set or clear one bit of the value of a key", Arity: 4, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).setBit},
+	{Name: "GETBIT", Group: "bitmap", Summary: "Get one bit of the value of a key", Arity: 3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).getBit},
+	{Name: "BITCOUNT", Group: "bitmap", Summary: "Count the set bits of the value of a key", Arity: -2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).bitCount},
+	{Name: "BITPOS", Group: "bitmap", Summary: "Find the first set or clear bit of the value of a key", Arity: -3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).bitPos},
+	{Name: "BITOP", Group: "bitmap", Summary: "Combine the values of keys bitwise into another key", Arity: -4, FirstKey: 2, LastKey: -1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).bitOp},
+
+	//keyspace
+	{Name: "DEL", Group: "generic", Summary: "Delete keys", Arity: -2, FirstKey: 1, LastKey: -1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).del},
+	{Name: "UNLINK", Group: "generic", Summary: "Delete keys", Arity: -2, FirstKey: 1, LastKey: -1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).del},
+	{Name: "EXISTS", Group: "generic", Summary: "Count how many of the keys exist", Arity: -2, FirstKey: 1, LastKey: -1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).exists},
+	{Name: "TOUCH", Group: "generic", Summary: "Count how many of the keys exist", Arity: -2, FirstKey: 1, LastKey: -1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).touch},
+	{Name: "EXPIRE", Group: "generic", Summary: "Set the time to live of a key in seconds", Arity: 3, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).expire},
+	{Name: "TTL", Group: "generic", Summary: "Get the time to live of a key in seconds", Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).ttl},
+	{Name: "TYPE", Group: "generic", Summary: "Get the type of the value of a key", Arity: 2, FirstKey: 1, LastKey: 1, KeyStep: 1, Flags: FlagReadOnly, Handler: (*Context).keyType},
+	{Name: "RENAME", Group: "generic", Summary: "Rename a key", Arity: 3, FirstKey: 1, LastKey: 2, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).rename},
+	{Name: "RENAMENX", Group: "generic", Summary: "Rename a key only if the new name does not exist", Arity: 3, FirstKey: 1, LastKey: 2, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).renameNX},
+	{Name: "COPY", Group: "generic", Summary: "Copy the value of a key to another key", Arity: -3, FirstKey: 1, LastKey: 2, KeyStep: 1, Flags: FlagWrite, Handler: (*Context).copyKey},
+	{Name: "DBSIZE", Group: "server", Summary: "Count the keys in the database", Arity: 1, Flags: FlagReadOnly | FlagKeyspace, Handler: (*Context).dbSize},
+	{Name: "RANDOMKEY", Group: "generic", Summary: "Return a random key", Arity: 1, Flags: FlagReadOnly | FlagKeyspace, Handler: (*Context).randomKey},
+	{Name: "SCAN", Group: "generic", Summary: "Incrementally iterate the keys", Arity: -2, Flags: FlagReadOnly | FlagKeyspace, Handler: (*Context).scan},
+	{Name: "FLUSHDB", Group: "server", Summary: "Delete every key", Arity: 1, Flags: FlagWrite | FlagKeyspace | FlagAdmin | FlagDangerous, Handler: (*Context).flushDB},
+
+	//scripting, scripts may only touch the keys they declare
+	{Name: "EVAL", Group: "scripting", Summary: "Run a Lua script over the declared keys", Arity: -3, Keys: scriptKeys, Flags: FlagWrite | FlagNoScript, Handler: (*Context).eval},
+	{Name: "EVALSHA", Group: "scripting", Summary: "Run a cached Lua script by its SHA1", Arity: -3, Keys: scriptKeys, Flags: FlagWrite | FlagNoScript, Handler: (*Context).evalSHA},
+	{Name: "SCRIPT", Group: "scripting", Summary: "Load, check or flush cached scripts", Arity: -2, Flags: FlagNoScript, Handler: (*Context).script},
+
+	//introspection
+	{Name: "COMMAND", Group: "server", Summary: "Describe the commands of the server", Arity: -1, Handler: (*Context).command},
+
+	//pub/sub, subscriptions are kept per connection by the server
+	{Name: "PUBLISH", Group: "pubsub", Summary: "Send a message to the subscribers of a channel", Arity: 3, Handler: (*Context).publish},
+	{Name: "PUBSUB", Group: "pubsub", Summary: "List active channels and count subscribers", Arity: -2, Handler: (*Context).pubSub},
+	{Name: "SUBSCRIBE", Group: "pubsub", Summary: "Listen for messages published to channels", Arity: -2, Flags: FlagConnection | FlagNoScript},
+	{Name: "UNSUBSCRIBE", Group: "pubsub", Summary: "Stop listening to channels, or to all of them", Arity: -1, Flags: FlagConnection | FlagNoScript},
+	{Name: "PSUBSCRIBE", Group: "pubsub", Summary: "Listen for messages published to channels matching glob patterns", Arity: -2, Flags: FlagConnection | FlagNoScript},
+	{Name: "PUNSUBSCRIBE", Group: "pubsub", Summary: "Stop listening to patterns, or to all of them", Arity: -1, Flags: FlagConnection | FlagNoScript},
+
+	//transactions, kept per connection by the server
+	{Name: "MULTI", Group: "transactions", Summary: "Start a transaction", Arity: 1, Flags: FlagConnection | FlagNoScript},
+	{Name: "EXEC", Group: "transactions", Summary: "Run the queued commands of a transaction", Arity: 1, Flags: FlagConnection | FlagNoScript},
+	{Name: "DISCARD", Group: "transactions", Summary: "Drop the queued commands of a transaction", Arity: 1, Flags: FlagConnection | FlagNoScript},
+	{Name: "WATCH", Group: "transactions", Summary: "Abort the next transaction if any of the keys change", Arity: -2, FirstKey: 1, LastKey: -1, KeyStep: 1, Flags: FlagConnection | FlagNoScript},
+	{Name: "UNWATCH", Group: "transactions", Summary: "Forget the watched keys", Arity: 1, Flags: FlagConnection | FlagNoScript},
+}
@@ -0,0 +1,418 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"tempDB/config"
+	"tempDB/utils"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// scriptCache holds the scripts known to EVALSHA, by the SHA1 of their body
+type scriptCache struct {
+	mutex   *sync.RWMutex
+	scripts map[string]string
+}
+
+func newScriptCache() *scriptCache {
+	return &scriptCache{
+		mutex:   &sync.RWMutex{},
+		scripts: make(map[string]string),
+	}
+}
+
+// add caches a script and returns its SHA1
+func (sc *scriptCache) add(body string) string {
+	sum := sha1.Sum([]byte(body))
+	sha := hex.EncodeToString(sum[:])
+
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.scripts[sha] = body
+	return sha
+}
+
+func (sc *scriptCache) get(sha string) (string, bool) {
+	sc.mutex.RLock()
+	defer sc.mutex.RUnlock()
+	body, exists := sc.scripts[strings.ToLower(sha)]
+	return body, exists
+}
+
+func (sc *scriptCache) flush() {
+	sc.mutex.Lock()
+	defer sc.mutex.Unlock()
+	sc.scripts = make(map[string]string)
+}
+
+// scriptKeys returns the keys declared by EVAL/EVALSHA script numkeys key [key ...] arg [arg ...],
+// or nil if numkeys is invalid
+func scriptKeys(params []string) []string {
+	if len(params) < 2 {
+		return nil
+	}
+	numKeys, err := strconv.Atoi(params[1])
+	if err != nil || numKeys < 0 || numKeys > len(params)-2 {
+		return nil
+	}
+	return params[2 : 2+numKeys]
+}
+
+// Handles the parameters for EVAL command
+func (c *Context) eval(params []string) ([]byte, error) {
+	//SCRIPT NUMKEYS [KEY ...] [ARG ...]
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR EVAL command requires a script and the number of keys\r\n")
+	}
+
+	c.db.scripts.add(params[0])
+	return c.runScript(params[0], params[1:])
+}
+
+// Handles the parameters for EVALSHA command
+func (c *Context) evalSHA(params []string) ([]byte, error) {
+	//SHA1 NUMKEYS [KEY ...] [ARG ...]
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR EVALSHA command requires a SHA1 and the number of keys\r\n")
+	}
+
+	body, exists := c.db.scripts.get(params[0])
+	if !exists {
+		return []byte(""), errors.New("-NOSCRIPT No matching script. Please use EVAL.\r\n")
+	}
+	return c.runScript(body, params[1:])
+}
+
+// Handles the parameters for SCRIPT command
+func (c *Context) script(params []string) ([]byte, error) {
+	//LOAD script | EXISTS sha1 [sha1 ...] | FLUSH
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR SCRIPT command requires a subcommand\r\n")
+	}
+
+	switch strings.ToUpper(params[0]) {
+	case "LOAD":
+		if len(params) != 2 {
+			return []byte(""), errors.New("-ERR SCRIPT LOAD requires a script\r\n")
+		}
+		//compile once so a broken script is rejected up front
+		ls := newScriptState()
+		defer ls.Close()
+		if _, err := ls.LoadString(params[1]); err != nil {
+			return []byte(""), fmt.Errorf("-ERR Error compiling script: %s\r\n", firstLine(err.Error()))
+		}
+		return []byte(fmt.Sprintf("+%s\r\n", c.db.scripts.add(params[1]))), nil
+	case "EXISTS":
+		response := []byte(fmt.Sprintf("*%d\r\n", len(params)-1))
+		for _, sha := range params[1:] {
+			if _, exists := c.db.scripts.get(sha); exists {
+				response = append(response, []byte(":1\r\n")...)
+			} else {
+				response = append(response, []byte(":0\r\n")...)
+			}
+		}
+		return response, nil
+	case "FLUSH":
+		c.db.scripts.flush()
+		return []byte("+OK\r\n"), nil
+	}
+	return []byte(""), errors.New("-ERR unknown SCRIPT subcommand\r\n")
+}
+
+// runScript runs a script over its declared keys, whose segments the caller
+// has locked. Commands called from the script run in the same context, so
+// their writes reach the WAL in the same batch as everything else it did.
+func (c *Context) runScript(body string, params []string) ([]byte, error) {
+	//NUMKEYS [KEY ...] [ARG ...]
+	numKeys, err := strconv.Atoi(params[0])
+	if err != nil || numKeys < 0 {
+		return []byte(""), errors.New("-ERR number of keys can't be negative or not an integer\r\n")
+	}
+	if numKeys > len(params)-1 {
+		return []byte(""), errors.New("-ERR number of keys can't be greater than number of args\r\n")
+	}
+	keys, args := params[1:1+numKeys], params[1+numKeys:]
+
+	//blocking commands called from the script return at once, as in MULTI
+	canBlock := c.canBlock
+	c.canBlock = false
+	defer func() { c.canBlock = canBlock }()
+
+	ls := newScriptState()
+	defer ls.Close()
+
+	//a script holds the locks of its keys, so one that never ends would
+	//block every client using them
+	limit := config.GetStoreConfig().LuaTimeLimitMs
+	if limit >= 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(limit)*time.Millisecond)
+		defer cancel()
+		ls.SetContext(ctx)
+	}
+
+	ls.SetGlobal("KEYS", stringTable(ls, keys))
+	ls.SetGlobal("ARGV", stringTable(ls, args))
+
+	declared := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		declared[key] = true
+	}
+
+	redis := ls.NewTable()
+	ls.SetField(redis, "call", ls.NewFunction(func(ls *lua.LState) int {
+		return c.scriptCall(ls, declared, true)
+	}))
+	ls.SetField(redis, "pcall", ls.NewFunction(func(ls *lua.LState) int {
+		return c.scriptCall(ls, declared, false)
+	}))
+	ls.SetField(redis, "sha1hex", ls.NewFunction(func(ls *lua.LState) int {
+		sum := sha1.Sum([]byte(ls.CheckString(1)))
+		ls.Push(lua.LString(hex.EncodeToString(sum[:])))
+		return 1
+	}))
+	ls.SetField(redis, "error_reply", ls.NewFunction(func(ls *lua.LState) int {
+		reply := ls.NewTable()
+		ls.SetField(reply, "err", lua.LString(ls.CheckString(1)))
+		ls.Push(reply)
+		return 1
+	}))
+	ls.SetField(redis, "status_reply", ls.NewFunction(func(ls *lua.LState) int {
+		reply := ls.NewTable()
+		ls.SetField(reply, "ok", lua.LString(ls.CheckString(1)))
+		ls.Push(reply)
+		return 1
+	}))
+	ls.SetGlobal("redis", redis)
+
+	fn, err := ls.LoadString(body)
+	if err != nil {
+		return []byte(""), fmt.Errorf("-ERR Error compiling script: %s\r\n", firstLine(err.Error()))
+	}
+	ls.Push(fn)
+	if err := ls.PCall(0, 1, nil); err != nil {
+		if ctx := ls.Context(); ctx != nil && ctx.Err() == context.DeadlineExceeded {
+			return []byte(""), fmt.Errorf("-ERR Error running script: exceeded lua_time_limit_ms of %d ms\r\n", limit)
+		}
+		return []byte(""), fmt.Errorf("-ERR Error running script: %s\r\n", firstLine(err.Error()))
+	}
+
+	return luaToReply(ls.Get(-1)), nil
+}
+
+// scriptCall implements redis.call (raise) and redis.pcall (return an error table)
+func (c *Context) scriptCall(ls *lua.LState, declared map[string]bool, raise bool) int {
+	fail := func(msg string) int {
+		if raise {
+			ls.RaiseError("%s", msg)
+			return 0
+		}
+		reply := ls.NewTable()
+		ls.SetField(reply, "err", lua.LString(msg))
+		ls.Push(reply)
+		return 1
+	}
+
+	if ls.GetTop() < 1 {
+		return fail("Please specify at least one argument for this redis lib call")
+	}
+	cmd := make([]string, ls.GetTop())
+	for i := range cmd {
+		switch v := ls.Get(i + 1).(type) {
+		case lua.LString:
+			cmd[i] = string(v)
+		case lua.LNumber:
+			cmd[i] = v.String()
+		default:
+			return fail("Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	cmd[0] = strings.ToUpper(cmd[0])
+
+	info, exists := LookupCommand(cmd[0])
+	if !exists {
+		return fail("Unknown Redis command called from script")
+	}
+	if !info.ValidArity(len(cmd)) {
+		return fail("Wrong number of args calling Redis command from script")
+	}
+	//only the declared keys are locked, so nothing spanning the keyspace may run
+	if info.Flags&(FlagNoScript|FlagConnection|FlagKeyspace) != 0 {
+		return fail("This Redis command is not allowed from script")
+	}
+	command := utils.Request{Command: info.Name, Params: cmd[1:]}
+
+	for _, key := range info.KeysOf(command.Params) {
+		if !declared[key] {
+			return fail(fmt.Sprintf("Script attempted to access key '%s' that was not declared in KEYS", key))
+		}
+	}
+	if c.authorize != nil {
+		if err := c.authorize(info, command.Params); err != nil {
+			return fail(strings.TrimPrefix(strings.TrimRight(string(ErrorReply(err)), "\r\n"), "-"))
+		}
+	}
+
+	reply, err := c.dispatch(command)
+	if err != nil {
+		return fail(strings.TrimPrefix(strings.TrimRight(string(ErrorReply(err)), "\r\n"), "-"))
+	}
+
+	value, err := replyToLua(ls, bufio.NewReader(bytes.NewReader(reply)))
+	if err != nil {
+		return fail(err.Error())
+	}
+	ls.Push(value)
+	return 1
+}
+
+// newScriptState creates a Lua VM with only the side-effect free libraries
+func newScriptState() *lua.LState {
+	ls := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		ls.Push(ls.NewFunction(lib.open))
+		ls.Push(lua.LString(lib.name))
+		ls.Call(1, 0)
+	}
+
+	//no access to the filesystem or to loading other code
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		ls.SetGlobal(name, lua.LNil)
+	}
+	return ls
+}
+
+// stringTable builds a Lua array from a slice of strings
+func stringTable(ls *lua.LState, values []string) *lua.LTable {
+	table := ls.CreateTable(len(values), 0)
+	for i, value := range values {
+		ls.RawSetInt(table, i+1, lua.LString(value))
+	}
+	return table
+}
+
+// replyToLua converts a command reply into the Lua value a script sees:
+// strings for simple and bulk strings, numbers for integers, tables for
+// arrays and false for nil
+func replyToLua(ls *lua.LState, r *bufio.Reader) (lua.LValue, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return lua.LNil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return lua.LNil, errors.New("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		if line[1:] == "(nil)" {
+			return lua.LFalse, nil
+		}
+		return lua.LString(line[1:]), nil
+	case '-':
+		reply := ls.NewTable()
+		ls.SetField(reply, "err", lua.LString(line[1:]))
+		return reply, nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return lua.LNil, err
+		}
+		return lua.LNumber(n), nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return lua.LNil, err
+		}
+		if n < 0 {
+			return lua.LFalse, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return lua.LNil, err
+		}
+		return lua.LString(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return lua.LNil, err
+		}
+		if n < 0 {
+			return lua.LFalse, nil
+		}
+		table := ls.CreateTable(n, 0)
+		for i := 1; i <= n; i++ {
+			element, err := replyToLua(ls, r)
+			if err != nil {
+				return lua.LNil, err
+			}
+			ls.RawSetInt(table, i, element)
+		}
+		return table, nil
+	}
+	return lua.LNil, fmt.Errorf("unknown reply type %q", line[0])
+}
+
+// luaToReply converts the value returned by a script into a reply
+func luaToReply(value lua.LValue) []byte {
+	switch v := value.(type) {
+	case lua.LString:
+		return []byte(fmt.Sprintf("+%s\r\n", string(v)))
+	case lua.LNumber:
+		//numbers are truncated to integers, as in redis
+		return []byte(fmt.Sprintf(":%d\r\n", int64(v)))
+	case lua.LBool:
+		if v {
+			return []byte(":1\r\n")
+		}
+	case *lua.LTable:
+		if msg, ok := v.RawGetString("err").(lua.LString); ok {
+			return ErrorReply(errors.New(string(msg)))
+		}
+		if msg, ok := v.RawGetString("ok").(lua.LString); ok {
+			return []byte(fmt.Sprintf("+%s\r\n", string(msg)))
+		}
+		//an array ends at its first nil
+		elements := [][]byte{}
+		for i := 1; ; i++ {
+			element := v.RawGetInt(i)
+			if element == lua.LNil {
+				break
+			}
+			elements = append(elements, luaToReply(element))
+		}
+		response := []byte(fmt.Sprintf("*%d\r\n", len(elements)))
+		for _, element := range elements {
+			response = append(response, element...)
+		}
+		return response
+	}
+	return []byte(fmt.Sprintf("+%s\r\n", "(nil)"))
+}
+
+// firstLine trims a Lua error down to its first line, dropping the stack trace
+func firstLine(msg string) string {
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		return msg[:i]
+	}
+	return msg
+}
@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"strings"
+	"tempDB/utils"
+	"testing"
+)
+
+func TestScriptTimeLimit(t *testing.T) {
+	db := newTestStore(t)
+
+	_, _, err := db.CommandHandler(utils.Request{Command: "EVAL", Params: []string{"redis.call('SET', KEYS[1], 'v') while true do end", "1", "k"}}, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "lua_time_limit_ms") {
+		t.Fatalf("EVAL of an endless script = %v, want the time limit error", err)
+	}
+
+	//the keys of the script are released, and what it wrote is kept
+	if got := run(t, db, "GET", "k"); got != "+v\r\n" {
+		t.Errorf("GET k = %q, want %q", got, "+v\r\n")
+	}
+	if got := run(t, db, "EVAL", "return 1", "0"); got != ":1\r\n" {
+		t.Errorf("EVAL after the time limit = %q, want %q", got, ":1\r\n")
+	}
+}
+
+func TestScriptBlockingCommands(t *testing.T) {
+	db := newTestStore(t)
+	run(t, db, "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+
+	//blocking commands return at once, with nothing to read
+	tests := []string{
+		"return redis.call('XREAD', 'BLOCK', '0', 'STREAMS', KEYS[1], '$')",
+		"return redis.call('XREADGROUP', 'GROUP', 'g', 'c', 'BLOCK', '0', 'STREAMS', KEYS[1], '>')",
+		"return redis.call('QPOP', KEYS[2], 'BLOCK', '0')",
+	}
+	for _, script := range tests {
+		t.Run(script, func(t *testing.T) {
+			response, _, err := db.CommandHandler(utils.Request{Command: "EVAL", Params: []string{script, "2", "s", "q"}}, nil, nil)
+			if err != nil {
+				t.Fatalf("EVAL = %v", err)
+			}
+			if strings.Contains(string(response), "blocked") {
+				t.Errorf("EVAL = %q, want the reply of a command that does not block", response)
+			}
+		})
+	}
+
+	//the command running the script may still block afterwards
+	run(t, db, "QPUSH", "q", "a")
+	if got := run(t, db, "QPOP", "q", "BLOCK", "0"); got != "*1\r\n*3\r\n:1\r\n:1\r\n+a\r\n" {
+		t.Errorf("QPOP after the scripts = %q", got)
+	}
+}
@@ -1,38 +1,57 @@
 package engine
 
 import (
-	"errors"
 	"fmt"
 	"hash/fnv"
+	"log/slog"
 	"runtime"
-	"strconv"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"tempDB/config"
+	"tempDB/logging"
+	"tempDB/pubsub"
 	"tempDB/utils"
 	"time"
 )
 
 type KeyValue struct {
 	Value    []byte
-	ExpireAt int64 // Unix timestamp for expiration, 0 means no expiration
+	ExpireAt int64   // Unix timestamp for expiration, 0 means no expiration
+	Version  uint64  // Bumped on every write, used by WATCH and CAS
+	Stream   *Stream `json:",omitempty"` // Set when the key holds a stream instead of a string
+	Queue    *Queue  `json:",omitempty"` // Set when the key holds a work queue instead of a string
+	Lock     *Lock   `json:",omitempty"` // Set when the key holds a lock instead of a string
 }
 
 type segment struct {
 	mutex         *sync.RWMutex
 	kv            map[string]KeyValue
 	cleanupTicker *time.Ticker
+
+	//keys in hash order for SCAN, rebuilt when a scan enters the segment
+	scanMutex *sync.Mutex
+	scanOrder []hashedKey
 }
 
 type Store struct {
 	segments           []*segment
 	numSegments        uint32
 	persistenceManager *PersistenceManager
+	versionClock       *atomic.Uint64
+	scripts            *scriptCache
+	broker             *pubsub.Broker
+	notifications      notifyClasses
+	waiters            *waitList
+	stats              *storeStats
+	log                *slog.Logger
 }
 
 func NewStore() Store {
 	//get db config
 	cfg := config.GetStoreConfig()
 	numSegments := uint32(runtime.NumCPU() * cfg.SegmentsPerCPU)
+	log := logging.For("engine")
 	segments := make([]*segment, numSegments)
 
 	//Get a new instance of Persistance Manager
@@ -47,72 +66,173 @@ func NewStore() Store {
 			mutex:         &sync.RWMutex{},
 			kv:            make(map[string]KeyValue),
 			cleanupTicker: time.NewTicker(time.Duration(cfg.CleanupIntervalSeconds) * time.Second),
+			scanMutex:     &sync.Mutex{},
 		}
-
-		//goroutine to check expiry for every segment
-		go segments[i].cleanupLoop()
 	}
 
 	s := Store{
 		segments:           segments,
 		numSegments:        numSegments,
 		persistenceManager: persistenceManager,
+		versionClock:       &atomic.Uint64{},
+		scripts:            newScriptCache(),
+		broker:             pubsub.NewBroker(),
+		notifications:      loadNotifyClasses(log),
+		waiters:            newWaitList(),
+		stats:              newStoreStats(),
+		log:                log,
+	}
+	s.registerMetrics()
+
+	//goroutine to check expiry for every segment
+	for _, seg := range segments {
+		go seg.cleanupLoop(s.keyExpired, s.waiters.signal)
 	}
 
 	// Load snapshot
-	fmt.Println("Reading snapshot...")
-	snapshotData, err := persistenceManager.LoadSnapshot()
+	log.Info("Reading snapshot")
+	snapshotData, versionClock, walSeq, err := persistenceManager.LoadSnapshot()
 	if err != nil {
-		fmt.Println("Failed to load snapshot:", err) // Log the error, but don't return it
+		log.Error("Failed to load snapshot", "err", err) // Log the error, but don't return it
 	}
+	s.observeVersion(versionClock)
 
 	// Apply snapshot data to segments
-	fmt.Println("Applying snapshot...")
+	log.Info("Applying snapshot", "keys", len(snapshotData))
 	for k, v := range snapshotData {
 		segment := s.getSegment(k)
 		segment.mutex.Lock()
 		segment.kv[k] = v
 		segment.mutex.Unlock()
+		s.observeVersion(v.Version)
 	}
 
 	// Replay WAL
-	fmt.Println("Replaying WAL...")
-	err = persistenceManager.ReplayWAL(func(record WALRecord) error {
-		segment := s.getSegment(record.Key)
-		segment.mutex.Lock()
-		defer segment.mutex.Unlock()
-
-		switch record.Command {
-		case "SET":
-			segment.kv[record.Key] = KeyValue{Value: record.Value, ExpireAt: record.ExpireAt}
-		case "DEL":
-			delete(segment.kv, record.Key)
-		case "EXPIRE":
-			if _, exists := segment.kv[record.Key]; exists {
-				segment.kv[record.Key] = KeyValue{Value: segment.kv[record.Key].Value, ExpireAt: record.ExpireAt}
-			}
-		case "FLUSHDB":
-			for _, seg := range s.segments {
-				seg.mutex.Lock()
-				defer seg.mutex.Unlock()
+	log.Info("Replaying WAL")
+	err = persistenceManager.ReplayWAL(walSeq, s.applyWALRecord)
 
-				// Clear the map
-				seg.kv = make(map[string]KeyValue)
+	if err != nil {
+		log.Error("Failed to replay WAL", "err", err) // Log the error, but don't return it
+	}
+
+	//keys loaded from files written before versions were persisted
+	//have none, so hand them fresh ones
+	for _, seg := range s.segments {
+		seg.mutex.Lock()
+		for k, v := range seg.kv {
+			if v.Version == 0 {
+				v.Version = s.versionClock.Add(1)
+				seg.kv[k] = v
 			}
 		}
-		return nil
-	})
-
-	if err != nil {
-		fmt.Println("Failed to replay WAL:", err) // Log the error, but don't return it
+		seg.mutex.Unlock()
 	}
 
 	//Start snapshotting
 	s.startSnapshotting()
 
+	//Start syncing the WAL to disk
+	persistenceManager.startSyncing(time.Duration(cfg.WALFlushIntervalSeconds) * time.Second)
+
 	return s
 }
 
+// observeVersion moves the version clock past a version read back from disk.
+// It is only used while loading, before any command runs.
+func (s *Store) observeVersion(version uint64) {
+	if version > s.versionClock.Load() {
+		s.versionClock.Store(version)
+	}
+}
+
+// applyWALRecord re-applies a single WAL record while replaying the log
+func (s *Store) applyWALRecord(record WALRecord) error {
+	s.observeVersion(record.Version)
+
+	switch record.Command {
+	case "BATCH":
+		for _, batched := range record.Batch {
+			if err := s.applyWALRecord(batched); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "FLUSHDB":
+		for _, seg := range s.segments {
+			seg.mutex.Lock()
+			// Clear the map
+			seg.kv = make(map[string]KeyValue)
+			seg.mutex.Unlock()
+		}
+		return nil
+	case "RENAME":
+		//Value holds the destination key
+		dst := string(record.Value)
+		unlock := s.lockSegments([]string{record.Key, dst}, true)
+		defer unlock()
+
+		src := s.getSegment(record.Key)
+		if kv, exists := src.kv[record.Key]; exists {
+			delete(src.kv, record.Key)
+			kv.Version = record.Version
+			s.getSegment(dst).kv[dst] = kv
+		}
+		return nil
+	}
+
+	segment := s.getSegment(record.Key)
+	segment.mutex.Lock()
+	defer segment.mutex.Unlock()
+
+	switch record.Command {
+	case "SET":
+		segment.kv[record.Key] = KeyValue{Value: record.Value, ExpireAt: record.ExpireAt, Version: record.Version, Stream: record.Stream, Queue: record.Queue, Lock: record.Lock}
+	case "DEL":
+		delete(segment.kv, record.Key)
+	case "EXPIRE":
+		if kv, exists := segment.kv[record.Key]; exists {
+			kv.ExpireAt = record.ExpireAt
+			kv.Version = record.Version
+			segment.kv[record.Key] = kv
+		}
+	case "APPEND":
+		kv := segment.kv[record.Key]
+		kv.Value = append(kv.Value, record.Value...)
+		kv.Version = record.Version
+		segment.kv[record.Key] = kv
+	case "SETRANGE":
+		kv := segment.kv[record.Key]
+		kv.Value = writeRange(kv.Value, record.Offset, record.Value)
+		kv.Version = record.Version
+		segment.kv[record.Key] = kv
+	case "SETBIT":
+		kv := segment.kv[record.Key]
+		kv.Value, _ = writeBit(kv.Value, record.Offset, record.Value[0])
+		kv.Version = record.Version
+		segment.kv[record.Key] = kv
+	default:
+		if isStreamRecord(record.Command) {
+			kv, exists := segment.kv[record.Key]
+			if !exists || kv.Stream == nil {
+				kv = KeyValue{Stream: newStream()}
+			}
+			kv.Stream.apply(record)
+			kv.Version = record.Version
+			segment.kv[record.Key] = kv
+		}
+		if isQueueRecord(record.Command) {
+			kv, exists := segment.kv[record.Key]
+			if !exists || kv.Queue == nil {
+				kv = KeyValue{Queue: newQueue()}
+			}
+			kv.Queue.apply(record)
+			kv.Version = record.Version
+			segment.kv[record.Key] = kv
+		}
+	}
+	return nil
+}
+
 // Start snapshotting
 func (s *Store) startSnapshotting() {
 	//Read config
@@ -121,10 +241,13 @@ func (s *Store) startSnapshotting() {
 	ticker := time.NewTicker(snapshotInterval)
 
 	go func() {
-		fmt.Println("Starting snapshotting...")
+		s.log.Info("Starting snapshotting", "interval", snapshotInterval.String())
 		for range ticker.C {
-			if err := s.createSnapshot(); err != nil {
-				fmt.Printf("ERR: Failed to create snapshot: %v\n", err)
+			start := time.Now()
+			err := s.createSnapshot()
+			s.stats.recordSnapshot(start, err)
+			if err != nil {
+				s.log.Error("Failed to create snapshot", "err", err)
 			}
 		}
 	}()
@@ -135,17 +258,28 @@ func (s *Store) createSnapshot() error {
 	//Create a map to hold the data for the snapshot
 	snapshotData := make(map[string]KeyValue)
 
-	//Iterate over all segments and copy the data
+	//Copy the data with every segment read-locked. Writes are logged before
+	//their segments are released, so the copy holds exactly the WAL records
+	//up to walSeq, which are skipped when replaying on top of it.
+	unlock := s.rlockAll()
 	for _, segment := range s.segments {
-		segment.mutex.RLock()
 		for k, v := range segment.kv {
+			//values can be modified in place (APPEND, SETRANGE, SETBIT),
+			//so copy them while the lock is held
+			v.Value = append([]byte(nil), v.Value...)
+			v.Stream = v.Stream.clone()
+			v.Queue = v.Queue.clone()
 			snapshotData[k] = v
 		}
-		segment.mutex.RUnlock()
 	}
 
+	//read after the copy, so the clock is at least every copied version
+	versionClock := s.versionClock.Load()
+	walSeq := s.persistenceManager.WALSeq()
+	unlock()
+
 	// Save snapshot
-	if err := s.persistenceManager.SaveSnapshot(snapshotData); err != nil {
+	if err := s.persistenceManager.SaveSnapshot(snapshotData, versionClock, walSeq); err != nil {
 		return fmt.Errorf("ERR: failed to save snapshot: %w", err)
 	}
 
@@ -154,76 +288,206 @@ func (s *Store) createSnapshot() error {
 		return fmt.Errorf("ERR: failed to rotate WAL after snapshot: %w", err)
 	}
 
-	fmt.Println("Snapshot and WAL rotation completed successfully")
+	s.log.Info("Snapshot and WAL rotation completed", "keys", len(snapshotData))
 	return nil
 }
 
 func (s *Store) getSegment(key string) *segment {
+	return s.segments[s.segmentIndex(key)]
+}
+
+func (s *Store) segmentIndex(key string) uint32 {
+	return keyHash(key) % s.numSegments
+}
+
+// keyHash is the hash placing a key in its segment, also used by SCAN to
+// order the keys within a segment
+func keyHash(key string) uint32 {
 	//Generate hash for Key
 	h := fnv.New32a()
 	h.Write([]byte(key))
-	return s.segments[h.Sum32()%s.numSegments]
+	return h.Sum32()
 }
 
-func (db *Store) CommandHandler(command utils.Request) ([]byte, error) {
+// lockSegments locks every distinct segment holding one of the keys, always in
+// ascending segment order so that concurrent multi-key commands cannot deadlock.
+// It returns the function releasing those locks.
+func (s *Store) lockSegments(keys []string, write bool) func() {
+	indexes := make([]uint32, 0, len(keys))
+	seen := make(map[uint32]bool, len(keys))
+	for _, key := range keys {
+		idx := s.segmentIndex(key)
+		if !seen[idx] {
+			seen[idx] = true
+			indexes = append(indexes, idx)
+		}
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
 
-	var record WALRecord
+	return s.lockIndexes(indexes, write)
+}
 
-	// Write to WAL
-	if command.Command == "FLUSHDB" || command.Command == "PING" { //FLUSHDB and PING commands don't have a key
+// lockAll write-locks every segment, in order
+func (s *Store) lockAll() func() {
+	return s.lockIndexes(s.allIndexes(), true)
+}
 
-		record = WALRecord{
-			Command:  command.Command,
-			Key:      "",       // Assuming first param is always the key
-			Value:    []byte{}, // Value will be set in specific command handlers
-			ExpireAt: 0,        // ExpireAt will be set in specific command handlers
-		}
+// rlockAll read-locks every segment, in order
+func (s *Store) rlockAll() func() {
+	return s.lockIndexes(s.allIndexes(), false)
+}
 
-	} else {
+func (s *Store) allIndexes() []uint32 {
+	indexes := make([]uint32, s.numSegments)
+	for i := range indexes {
+		indexes[i] = uint32(i)
+	}
+	return indexes
+}
 
-		record = WALRecord{
-			Command:  command.Command,
-			Key:      command.Params[0], // Assuming first param is always the key
-			Value:    []byte{},          // Value will be set in specific command handlers
-			ExpireAt: 0,                 // ExpireAt will be set in specific command handlers
+// lockIndexes locks the segments at the given (sorted) indexes
+func (s *Store) lockIndexes(indexes []uint32, write bool) func() {
+	for _, idx := range indexes {
+		if write {
+			s.segments[idx].mutex.Lock()
+		} else {
+			s.segments[idx].mutex.RLock()
 		}
 	}
 
-	if len(command.Params) > 1 {
-		record.Value = []byte(command.Params[1]) // Assuming second param is the value
+	return func() {
+		for _, idx := range indexes {
+			if write {
+				s.segments[idx].mutex.Unlock()
+			} else {
+				s.segments[idx].mutex.RUnlock()
+			}
+		}
 	}
+}
 
-	if command.Command == "EXPIRE" && len(command.Params) > 1 {
-		seconds, err := strconv.ParseInt(command.Params[1], 10, 64)
-		if err == nil {
-			record.ExpireAt = time.Now().Unix() + seconds
+// CommandHandler runs a command, checking the commands scripts call with
+// authorize unless it is nil. It also returns how long the command ran, not
+// counting the time it spent blocked. A blocked command stops waiting once
+// gone is closed, when the client went away, and returns no reply.
+func (db *Store) CommandHandler(command utils.Request, authorize Authorizer, gone <-chan struct{}) ([]byte, time.Duration, error) {
+	var deadline time.Time // set when a blocking command first blocks
+	var elapsed time.Duration
+	for {
+		start := time.Now()
+		unlock, allLocked := db.lockFor([]utils.Request{command}, nil)
+
+		c := &Context{db: db, allLocked: allLocked, canBlock: true, authorize: authorize}
+		response, err := c.dispatch(command)
+
+		//WAL records are written before the segments are released,
+		//so the log order matches the order the writes were applied in
+		c.flush()
+
+		blocked, isBlocked := err.(*blockedError)
+		if !isBlocked {
+			unlock()
+			elapsed += time.Since(start)
+			observeCommand(command.Command, elapsed)
+			return response, elapsed, err
 		}
+
+		//the waiter is added before the segments are released,
+		//so a write right after can not be missed
+		wake := db.waiters.add(blocked.keys)
+		unlock()
+		elapsed += time.Since(start)
+
+		if deadline.IsZero() && blocked.timeout > 0 {
+			deadline = time.Now().Add(blocked.timeout)
+		}
+		woken := db.waiters.wait(wake, deadline, gone)
+		db.waiters.remove(blocked.keys, wake)
+		select {
+		case <-gone:
+			//nothing is read or leased for a client that is not there
+			observeCommand(command.Command, elapsed)
+			return nil, elapsed, nil
+		default:
+		}
+		if !woken {
+			observeCommand(command.Command, elapsed)
+			return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), elapsed, nil
+		}
+		command.Params = blocked.retry
+	}
+}
+
+// dispatch runs a single command whose segments are already locked
+func (c *Context) dispatch(command utils.Request) ([]byte, error) {
+	cmd, exists := LookupCommand(command.Command)
+	if !exists || cmd.Handler == nil {
+		return []byte(""), fmt.Errorf("-ERR unknown command '%s'\r\n", command.Command)
 	}
 
-	if db.persistenceManager != nil {
-		if err := db.persistenceManager.WriteWALRecord(record); err != nil {
-			fmt.Println("Failed to write to WAL:", err) // Log the error, but don't return it
+	//only commands flagged as writes reach the WAL
+	logged := len(c.records)
+	c.countLookups(cmd, command.Params)
+	response, err := cmd.Handler(c, command.Params)
+	if cmd.Flags&FlagWrite == 0 {
+		c.records = c.records[:logged]
+	}
+	return response, err
+}
+
+// lockAccess describes how a command needs its segments locked
+type lockAccess int
+
+const (
+	accessNone  lockAccess = iota // touches no keys
+	accessRead                    // read locks on the segments of its keys
+	accessWrite                   // write locks on the segments of its keys
+	accessAll                     // write locks on every segment
+	accessSelf                    // locks each segment itself, one at a time
+)
+
+// commandKeys returns the keys a command touches and how it needs them locked
+func commandKeys(command utils.Request) ([]string, lockAccess) {
+	cmd, exists := LookupCommand(command.Command)
+	if !exists {
+		return nil, accessNone
+	}
+	return cmd.KeysOf(command.Params), cmd.access()
+}
+
+// lockFor locks the segments needed to run the commands together, plus those
+// of any extra keys. A single command gets exactly the access it asks for;
+// several commands get write locks over the union of their keys, or over every
+// segment if any of them spans the whole keyspace. It returns the function
+// releasing the locks and whether every segment is held.
+func (db *Store) lockFor(commands []utils.Request, extraKeys []string) (func(), bool) {
+	if len(commands) == 1 && len(extraKeys) == 0 {
+		keys, access := commandKeys(commands[0])
+		switch access {
+		case accessRead:
+			return db.lockSegments(keys, false), false
+		case accessWrite:
+			return db.lockSegments(keys, true), false
+		case accessAll:
+			return db.lockAll(), true
 		}
+		return func() {}, false
 	}
 
-	switch command.Command {
-	case "PING":
-		return db.Ping()
-	case "GET":
-		return db.Get(command.Params)
-	case "SET":
-		return db.Set(command.Params)
-	case "DEL":
-		return db.Del(command.Params)
-	case "FLUSHDB":
-		return db.FlushDB()
-	case "EXPIRE":
-		return db.Expire(command.Params)
-	case "TTL":
-		return db.TTL(command.Params)
-	default:
-		return []byte(""), errors.New("invalid command")
+	keys := append([]string{}, extraKeys...)
+	for _, command := range commands {
+		touched, access := commandKeys(command)
+		if access == accessAll || access == accessSelf {
+			return db.lockAll(), true
+		}
+		keys = append(keys, touched...)
 	}
+	return db.lockSegments(keys, true), false
+}
+
+// Broker returns the pub/sub broker of the store
+func (db *Store) Broker() *pubsub.Broker {
+	return db.broker
 }
 
 // Close closes the store and its persistence manager.
@@ -237,16 +501,50 @@ func (db *Store) Close() error {
 	return nil
 }
 
-// Cleanup per Segment
-func (seg *segment) cleanupLoop() {
+// lookup returns the entry stored under key, treating an expired entry as absent.
+// Expired entries are left in place for the cleanup loop (or a writer) to remove,
+// so it is safe to call with only the read lock held.
+func (seg *segment) lookup(key string) (KeyValue, bool) {
+	kv, exists := seg.kv[key]
+	if !exists || kv.expired(time.Now().Unix()) {
+		return KeyValue{}, false
+	}
+	return kv, true
+}
+
+// expired reports whether the entry's expiry is set and has passed at now
+func (kv KeyValue) expired(now int64) bool {
+	return kv.ExpireAt != 0 && now > kv.ExpireAt
+}
+
+// Cleanup per Segment. Besides removing expired keys, it ends the queue
+// leases that ran out and calls onReady for the queues holding messages
+// ready to be leased, so blocked consumers see delayed and expired messages.
+func (seg *segment) cleanupLoop(onExpired func(key string), onReady func(key string)) {
 	for range seg.cleanupTicker.C {
 		seg.mutex.Lock()
-		now := time.Now().Unix()
+		now := time.Now()
+		expired, ready := []string{}, []string{}
 		for k, v := range seg.kv {
-			if v.ExpireAt != 0 && now > v.ExpireAt {
+			if v.expired(now.Unix()) {
 				delete(seg.kv, k)
+				expired = append(expired, k)
+				continue
+			}
+			if v.Queue != nil {
+				v.Queue.expireLeases(now.UnixMilli())
+				if len(v.Queue.ready(now.UnixMilli(), 1)) > 0 {
+					ready = append(ready, k)
+				}
 			}
 		}
 		seg.mutex.Unlock()
+
+		for _, k := range expired {
+			onExpired(k)
+		}
+		for _, k := range ready {
+			onReady(k)
+		}
 	}
 }
@@ -0,0 +1,369 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errWrongType = errors.New("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+// StreamID identifies a stream entry: a Unix millisecond time and a
+// sequence number telling apart the entries added within that millisecond
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+// MarshalText lets IDs key the pending entries map in snapshots
+func (id StreamID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *StreamID) UnmarshalText(text []byte) error {
+	parsed, err := parseStreamID(string(text), 0)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+func (id StreamID) less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// next returns the smallest ID after id, false if id is the largest one
+func (id StreamID) next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// prev returns the largest ID before id, false if id is the smallest one
+func (id StreamID) prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// parseStreamID parses "ms-seq", or "ms" with seq as the sequence number
+func parseStreamID(s string, seq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, errors.New("-ERR Invalid stream ID specified as stream command argument\r\n")
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return StreamID{}, errors.New("-ERR Invalid stream ID specified as stream command argument\r\n")
+		}
+	}
+	return StreamID{ms, seq}, nil
+}
+
+// parseRangeID parses a range bound: an ID, "-" or "+", or an ID prefixed
+// with "(" to exclude it. It reports false when an exclusive bound leaves
+// nothing in the range.
+func parseRangeID(s string, start bool) (StreamID, bool, error) {
+	switch s {
+	case "-":
+		return StreamID{}, true, nil
+	case "+":
+		return StreamID{math.MaxUint64, math.MaxUint64}, true, nil
+	}
+
+	//a bare time covers the whole millisecond
+	seq := uint64(0)
+	if !start {
+		seq = math.MaxUint64
+	}
+
+	exclusive := strings.HasPrefix(s, "(")
+	id, err := parseStreamID(strings.TrimPrefix(s, "("), seq)
+	if err != nil || !exclusive {
+		return id, true, err
+	}
+	if start {
+		id, ok := id.next()
+		return id, ok, nil
+	}
+	id, ok := id.prev()
+	return id, ok, nil
+}
+
+// StreamEntry is a single entry of a stream
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string // field value pairs
+}
+
+// PendingEntry is an entry delivered to a consumer and not acknowledged yet
+type PendingEntry struct {
+	Consumer    string
+	DeliveredAt int64 // Unix milliseconds of the last delivery
+	Deliveries  int64
+}
+
+// ConsumerGroup tracks what was delivered to the consumers of a group
+type ConsumerGroup struct {
+	LastDelivered StreamID
+	Pending       map[StreamID]*PendingEntry
+	Consumers     map[string]int64 // consumer name to the Unix milliseconds it was last seen
+}
+
+// Stream is an append-only log of entries with increasing IDs
+type Stream struct {
+	Entries []StreamEntry
+	LastID  StreamID // ID of the last entry ever added, kept when entries are trimmed
+	Groups  map[string]*ConsumerGroup
+}
+
+func newStream() *Stream {
+	return &Stream{Groups: make(map[string]*ConsumerGroup)}
+}
+
+func newConsumerGroup(lastDelivered StreamID) *ConsumerGroup {
+	return &ConsumerGroup{
+		LastDelivered: lastDelivered,
+		Pending:       make(map[StreamID]*PendingEntry),
+		Consumers:     make(map[string]int64),
+	}
+}
+
+// clone deep copies the stream, which is otherwise modified in place
+func (st *Stream) clone() *Stream {
+	if st == nil {
+		return nil
+	}
+	copied := &Stream{
+		Entries: append([]StreamEntry(nil), st.Entries...),
+		LastID:  st.LastID,
+		Groups:  make(map[string]*ConsumerGroup, len(st.Groups)),
+	}
+	for name, group := range st.Groups {
+		g := newConsumerGroup(group.LastDelivered)
+		for id, pending := range group.Pending {
+			p := *pending
+			g.Pending[id] = &p
+		}
+		for consumer, seen := range group.Consumers {
+			g.Consumers[consumer] = seen
+		}
+		copied.Groups[name] = g
+	}
+	return copied
+}
+
+// search returns the index of the first entry whose ID is not below id
+func (st *Stream) search(id StreamID) int {
+	return sort.Search(len(st.Entries), func(i int) bool {
+		return !st.Entries[i].ID.less(id)
+	})
+}
+
+// entry returns the entry with the given ID
+func (st *Stream) entry(id StreamID) (StreamEntry, bool) {
+	i := st.search(id)
+	if i < len(st.Entries) && st.Entries[i].ID == id {
+		return st.Entries[i], true
+	}
+	return StreamEntry{}, false
+}
+
+// between returns up to count entries with IDs from start to end, newest
+// first when rev is set. A count below 1 means no limit.
+func (st *Stream) between(start, end StreamID, count int, rev bool) []StreamEntry {
+	from, to := st.search(start), len(st.Entries)
+	if next, ok := end.next(); ok {
+		to = st.search(next)
+	}
+	if from >= to {
+		return nil
+	}
+
+	entries := st.Entries[from:to]
+	if count > 0 && count < len(entries) {
+		if rev {
+			entries = entries[len(entries)-count:]
+		} else {
+			entries = entries[:count]
+		}
+	}
+	if !rev {
+		return entries
+	}
+
+	reversed := make([]StreamEntry, len(entries))
+	for i, entry := range entries {
+		reversed[len(entries)-1-i] = entry
+	}
+	return reversed
+}
+
+// nextID generates the ID of an entry added at now, in Unix milliseconds
+func (st *Stream) nextID(now uint64) (StreamID, error) {
+	if now > st.LastID.Ms {
+		return StreamID{now, 0}, nil
+	}
+	//the clock went back or the millisecond is taken, keep counting
+	id, ok := st.LastID.next()
+	if !ok {
+		return id, errors.New("-ERR The stream has exhausted the last possible ID, unable to add more items\r\n")
+	}
+	return id, nil
+}
+
+// sortedPending returns the IDs of the pending entries of a group in order
+func (g *ConsumerGroup) sortedPending() []StreamID {
+	ids := make([]StreamID, 0, len(g.Pending))
+	for id := range g.Pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+// apply applies a stream WAL record. Commands build the record of a change
+// and apply it, so replaying the log repeats exactly what they did.
+func (st *Stream) apply(record WALRecord) {
+	switch record.Command {
+	case "XADD":
+		//ID FIELD VALUE [FIELD VALUE ...]
+		id, _ := parseStreamID(record.Args[0], 0)
+		st.Entries = append(st.Entries, StreamEntry{ID: id, Fields: record.Args[1:]})
+		st.LastID = id
+	case "XTRIM":
+		//Offset holds how many entries were removed from the front
+		n := int(record.Offset)
+		if n > len(st.Entries) {
+			n = len(st.Entries)
+		}
+		st.Entries = st.Entries[n:]
+	case "XGROUP":
+		//CREATE|SETID GROUP ID, DESTROY GROUP, CREATECONSUMER|DELCONSUMER GROUP CONSUMER
+		group := st.Groups[record.Args[1]]
+		switch record.Args[0] {
+		case "CREATE":
+			id, _ := parseStreamID(record.Args[2], 0)
+			st.Groups[record.Args[1]] = newConsumerGroup(id)
+		case "SETID":
+			if group != nil {
+				group.LastDelivered, _ = parseStreamID(record.Args[2], 0)
+			}
+		case "DESTROY":
+			delete(st.Groups, record.Args[1])
+		case "CREATECONSUMER":
+			if group != nil {
+				group.Consumers[record.Args[2]] = record.Offset
+			}
+		case "DELCONSUMER":
+			if group != nil {
+				delete(group.Consumers, record.Args[2])
+				for id, pending := range group.Pending {
+					if pending.Consumer == record.Args[2] {
+						delete(group.Pending, id)
+					}
+				}
+			}
+		}
+	case "XREADGROUP":
+		//GROUP CONSUMER NOACK ID [ID ...], Offset holds the delivery time
+		group := st.Groups[record.Args[0]]
+		if group == nil {
+			return
+		}
+		consumer, noAck := record.Args[1], record.Args[2] == "1"
+		group.Consumers[consumer] = record.Offset
+		for _, arg := range record.Args[3:] {
+			id, _ := parseStreamID(arg, 0)
+			if group.LastDelivered.less(id) {
+				group.LastDelivered = id
+			}
+			if noAck {
+				continue
+			}
+			pending, exists := group.Pending[id]
+			if !exists {
+				pending = &PendingEntry{}
+				group.Pending[id] = pending
+			}
+			pending.Consumer = consumer
+			pending.DeliveredAt = record.Offset
+			pending.Deliveries++
+		}
+	case "XCLAIM":
+		//GROUP CONSUMER JUSTID ID [ID ...], Offset holds the claim time
+		group := st.Groups[record.Args[0]]
+		if group == nil {
+			return
+		}
+		consumer, justID := record.Args[1], record.Args[2] == "1"
+		group.Consumers[consumer] = record.Offset
+		for _, arg := range record.Args[3:] {
+			id, _ := parseStreamID(arg, 0)
+			if pending, exists := group.Pending[id]; exists {
+				pending.Consumer = consumer
+				pending.DeliveredAt = record.Offset
+				if !justID {
+					pending.Deliveries++
+				}
+			}
+		}
+	case "XACK":
+		//GROUP ID [ID ...]
+		group := st.Groups[record.Args[0]]
+		if group == nil {
+			return
+		}
+		for _, arg := range record.Args[1:] {
+			id, _ := parseStreamID(arg, 0)
+			delete(group.Pending, id)
+		}
+	}
+}
+
+// isStreamRecord reports whether a WAL record is applied through Stream.apply
+func isStreamRecord(command string) bool {
+	switch command {
+	case "XADD", "XTRIM", "XGROUP", "XREADGROUP", "XCLAIM", "XACK":
+		return true
+	}
+	return false
+}
+
+// lookupString returns the value of a key, which must hold a string if it exists
+func (seg *segment) lookupString(key string) (KeyValue, bool, error) {
+	kv, exists := seg.lookup(key)
+	if exists && !kv.isString() {
+		return KeyValue{}, false, errWrongType
+	}
+	return kv, exists, nil
+}
+
+// lookupStream returns the stream stored under a key, nil if the key does not exist
+func (seg *segment) lookupStream(key string) (KeyValue, *Stream, error) {
+	kv, exists := seg.lookup(key)
+	if !exists {
+		return kv, nil, nil
+	}
+	if kv.Stream == nil {
+		return kv, nil, errWrongType
+	}
+	return kv, kv.Stream, nil
+}
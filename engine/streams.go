@@ -0,0 +1,975 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStreamID is the largest possible ID, the end of open ranges
+var maxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+// nowMs returns the current Unix time in milliseconds
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// putStream stores a stream whose records were just applied to it, and logs them
+func (c *Context) putStream(seg *segment, key string, kv KeyValue, records ...WALRecord) {
+	version := c.put(seg, key, kv)
+	for _, record := range records {
+		record.Key = key
+		record.Version = version
+		c.log(record)
+	}
+}
+
+// entryReply encodes an entry as its ID followed by its field value pairs
+func entryReply(entry StreamEntry) []byte {
+	response := []byte(fmt.Sprintf("*2\r\n+%s\r\n*%d\r\n", entry.ID, len(entry.Fields)))
+	for _, field := range entry.Fields {
+		response = append(response, []byte(fmt.Sprintf("+%s\r\n", field))...)
+	}
+	return response
+}
+
+// entriesReply encodes a list of entries
+func entriesReply(entries []StreamEntry) []byte {
+	response := []byte(fmt.Sprintf("*%d\r\n", len(entries)))
+	for _, entry := range entries {
+		response = append(response, entryReply(entry)...)
+	}
+	return response
+}
+
+// idsReply encodes a list of IDs
+func idsReply(ids []StreamID) []byte {
+	response := []byte(fmt.Sprintf("*%d\r\n", len(ids)))
+	for _, id := range ids {
+		response = append(response, []byte(fmt.Sprintf("+%s\r\n", id))...)
+	}
+	return response
+}
+
+// trimOptions holds a parsed MAXLEN or MINID trimming strategy
+type trimOptions struct {
+	enabled bool
+	maxLen  int      // MAXLEN: number of entries to keep
+	minID   StreamID // MINID: lowest ID to keep
+	byID    bool
+}
+
+// parseTrim parses MAXLEN|MINID [=|~] threshold [LIMIT count] at the start
+// of args and returns how many arguments it used. Approximate trimming (~)
+// and LIMIT are accepted, but the stream is always trimmed exactly.
+func parseTrim(args []string) (trimOptions, int, error) {
+	opts := trimOptions{enabled: true, byID: strings.ToUpper(args[0]) == "MINID"}
+	i := 1
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		i++
+	}
+	if i >= len(args) {
+		return opts, 0, errors.New("-ERR syntax error\r\n")
+	}
+
+	if opts.byID {
+		id, err := parseStreamID(args[i], 0)
+		if err != nil {
+			return opts, 0, err
+		}
+		opts.minID = id
+	} else {
+		maxLen, err := strconv.Atoi(args[i])
+		if err != nil || maxLen < 0 {
+			return opts, 0, errors.New("-ERR The MAXLEN argument must be >= 0.\r\n")
+		}
+		opts.maxLen = maxLen
+	}
+	i++
+
+	if i+1 < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		if _, err := strconv.Atoi(args[i+1]); err != nil {
+			return opts, 0, errors.New("-ERR value is not an integer or out of range\r\n")
+		}
+		i += 2
+	}
+	return opts, i, nil
+}
+
+// trimCount returns how many entries trimming removes from the front
+func (st *Stream) trimCount(opts trimOptions) int {
+	if opts.byID {
+		return st.search(opts.minID)
+	}
+	if len(st.Entries) > opts.maxLen {
+		return len(st.Entries) - opts.maxLen
+	}
+	return 0
+}
+
+// Handles the parameters for XADD command
+func (c *Context) xAdd(params []string) ([]byte, error) {
+	//KEY [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|ID FIELD VALUE [FIELD VALUE ...]
+	if len(params) < 4 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'xadd' command\r\n")
+	}
+
+	key := params[0]
+	noMkStream := false
+	var trim trimOptions
+
+	i := 1
+options:
+	for i < len(params) {
+		switch strings.ToUpper(params[i]) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			i++
+		case "MAXLEN", "MINID":
+			opts, n, err := parseTrim(params[i:])
+			if err != nil {
+				return []byte(""), err
+			}
+			trim = opts
+			i += n
+		default:
+			break options
+		}
+	}
+
+	fields := []string{}
+	if i < len(params) {
+		fields = params[i+1:]
+	}
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'xadd' command\r\n")
+	}
+
+	seg := c.db.getSegment(key)
+	kv, st, err := seg.lookupStream(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if st == nil {
+		if noMkStream {
+			return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+		}
+		st = newStream()
+		kv = KeyValue{Stream: st}
+		//logged whole, as the log may still hold an expired stream under
+		//the key that replaying the entry alone would add to
+		c.Put(key, kv)
+	}
+
+	id, err := st.resolveAddID(params[i])
+	if err != nil {
+		return []byte(""), err
+	}
+
+	add := WALRecord{Command: "XADD", Args: append([]string{id.String()}, fields...)}
+	st.apply(add)
+	records := []WALRecord{add}
+	if trim.enabled {
+		if n := st.trimCount(trim); n > 0 {
+			record := WALRecord{Command: "XTRIM", Offset: int64(n)}
+			st.apply(record)
+			records = append(records, record)
+		}
+	}
+	c.putStream(seg, key, kv, records...)
+
+	return []byte(fmt.Sprintf("+%s\r\n", id)), nil
+}
+
+// resolveAddID turns the ID given to XADD (*, ms-* or ms-seq) into the ID of the new entry
+func (st *Stream) resolveAddID(arg string) (StreamID, error) {
+	if arg == "*" {
+		return st.nextID(uint64(nowMs()))
+	}
+
+	var id StreamID
+	if ms, found := strings.CutSuffix(arg, "-*"); found {
+		parsed, err := parseStreamID(ms, 0)
+		if err != nil {
+			return id, err
+		}
+		id = parsed
+		if id.Ms == st.LastID.Ms {
+			next, ok := st.LastID.next()
+			if !ok {
+				return id, errors.New("-ERR The stream has exhausted the last possible ID, unable to add more items\r\n")
+			}
+			id = next
+		}
+	} else {
+		parsed, err := parseStreamID(arg, 0)
+		if err != nil {
+			return id, err
+		}
+		id = parsed
+	}
+
+	if id == (StreamID{}) {
+		return id, errors.New("-ERR The ID specified in XADD must be greater than 0-0\r\n")
+	}
+	if !st.LastID.less(id) {
+		return id, errors.New("-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")
+	}
+	return id, nil
+}
+
+// Handles the parameters for XLEN command
+func (c *Context) xLen(params []string) ([]byte, error) {
+	//KEY
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR XLEN command requires a key\r\n")
+	}
+
+	_, st, err := c.db.getSegment(params[0]).lookupStream(params[0])
+	if err != nil {
+		return []byte(""), err
+	}
+	if st == nil {
+		return []byte(":0\r\n"), nil
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", len(st.Entries))), nil
+}
+
+// Handles the parameters for XRANGE command
+func (c *Context) xRange(params []string) ([]byte, error) {
+	//KEY START END [COUNT count]
+	return c.streamRange(params, false)
+}
+
+// Handles the parameters for XREVRANGE command
+func (c *Context) xRevRange(params []string) ([]byte, error) {
+	//KEY END START [COUNT count]
+	return c.streamRange(params, true)
+}
+
+// streamRange runs XRANGE, or XREVRANGE (with the bounds swapped) when rev is set
+func (c *Context) streamRange(params []string, rev bool) ([]byte, error) {
+	if len(params) != 3 && len(params) != 5 {
+		return []byte(""), errors.New("-ERR syntax error\r\n")
+	}
+
+	key, first, last := params[0], params[1], params[2]
+	if rev {
+		first, last = last, first
+	}
+
+	count := 0 // no limit
+	if len(params) == 5 {
+		if strings.ToUpper(params[3]) != "COUNT" {
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+		n, err := strconv.Atoi(params[4])
+		if err != nil || n < 0 {
+			return []byte(""), errors.New("-ERR value is not an integer or out of range\r\n")
+		}
+		if n == 0 {
+			return []byte("*0\r\n"), nil
+		}
+		count = n
+	}
+
+	start, startOk, err := parseRangeID(first, true)
+	if err != nil {
+		return []byte(""), err
+	}
+	end, endOk, err := parseRangeID(last, false)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	_, st, err := c.db.getSegment(key).lookupStream(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if st == nil || !startOk || !endOk {
+		return []byte("*0\r\n"), nil
+	}
+	return entriesReply(st.between(start, end, count, rev)), nil
+}
+
+// Handles the parameters for XTRIM command
+func (c *Context) xTrim(params []string) ([]byte, error) {
+	//KEY MAXLEN|MINID [=|~] threshold [LIMIT count]
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'xtrim' command\r\n")
+	}
+	if strategy := strings.ToUpper(params[1]); strategy != "MAXLEN" && strategy != "MINID" {
+		return []byte(""), errors.New("-ERR syntax error\r\n")
+	}
+	trim, n, err := parseTrim(params[1:])
+	if err != nil {
+		return []byte(""), err
+	}
+	if 1+n != len(params) {
+		return []byte(""), errors.New("-ERR syntax error\r\n")
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+	kv, st, err := seg.lookupStream(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if st == nil {
+		return []byte(":0\r\n"), nil
+	}
+
+	removed := st.trimCount(trim)
+	if removed > 0 {
+		record := WALRecord{Command: "XTRIM", Offset: int64(removed)}
+		st.apply(record)
+		c.putStream(seg, key, kv, record)
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", removed)), nil
+}
+
+// readOptions holds the parsed arguments of XREAD and XREADGROUP
+type readOptions struct {
+	group    string
+	consumer string
+	count    int // 0 for no limit
+	blocking bool
+	block    time.Duration
+	noAck    bool
+	keys     []string
+	ids      []string
+}
+
+// parseReadArgs parses [GROUP group consumer] [COUNT count] [BLOCK ms]
+// [NOACK] STREAMS key [key ...] id [id ...], the group options only being
+// accepted by XREADGROUP
+func parseReadArgs(args []string, withGroup bool) (readOptions, error) {
+	var opts readOptions
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return opts, errors.New("-ERR syntax error\r\n")
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 0 {
+				return opts, errors.New("-ERR value is not an integer or out of range\r\n")
+			}
+			opts.count = n
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return opts, errors.New("-ERR syntax error\r\n")
+			}
+			ms, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || ms < 0 {
+				return opts, errors.New("-ERR timeout is not an integer or out of range\r\n")
+			}
+			opts.blocking, opts.block = true, time.Duration(ms)*time.Millisecond
+			i++
+		case "GROUP":
+			if !withGroup || i+2 >= len(args) {
+				return opts, errors.New("-ERR syntax error\r\n")
+			}
+			opts.group, opts.consumer = args[i+1], args[i+2]
+			i += 2
+		case "NOACK":
+			if !withGroup {
+				return opts, errors.New("-ERR syntax error\r\n")
+			}
+			opts.noAck = true
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return opts, errors.New("-ERR Unbalanced XREAD list of streams: for each stream key an ID or '$' must be specified.\r\n")
+			}
+			opts.keys, opts.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+			if withGroup && opts.group == "" {
+				return opts, errors.New("-ERR Missing GROUP option for XREADGROUP\r\n")
+			}
+			return opts, nil
+		default:
+			return opts, errors.New("-ERR syntax error\r\n")
+		}
+	}
+	return opts, errors.New("-ERR syntax error\r\n")
+}
+
+// xReadKeys returns the keys of an XREAD command
+func xReadKeys(args []string) []string {
+	opts, _ := parseReadArgs(args, false)
+	return opts.keys
+}
+
+// xReadGroupKeys returns the keys of an XREADGROUP command
+func xReadGroupKeys(args []string) []string {
+	opts, _ := parseReadArgs(args, true)
+	return opts.keys
+}
+
+// streamsReply encodes the entries read from several streams, skipping the
+// streams listed in skip
+func streamsReply(keys []string, entries [][]byte, skip []bool) []byte {
+	n := 0
+	for i := range keys {
+		if !skip[i] {
+			n++
+		}
+	}
+	response := []byte(fmt.Sprintf("*%d\r\n", n))
+	for i, key := range keys {
+		if !skip[i] {
+			response = append(response, []byte(fmt.Sprintf("*2\r\n+%s\r\n", key))...)
+			response = append(response, entries[i]...)
+		}
+	}
+	return response
+}
+
+// Handles the parameters for XREAD command
+func (c *Context) xRead(params []string) ([]byte, error) {
+	//[COUNT count] [BLOCK ms] STREAMS key [key ...] id|$ [id|$ ...]
+	opts, err := parseReadArgs(params, false)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	//$ is resolved once, so a retry after blocking reads what came after it
+	retry := append([]string(nil), params...)
+	idsAt := len(params) - len(opts.ids)
+
+	replies := make([][]byte, len(opts.keys))
+	empty := make([]bool, len(opts.keys))
+	found := false
+	for i, key := range opts.keys {
+		_, st, err := c.db.getSegment(key).lookupStream(key)
+		if err != nil {
+			return []byte(""), err
+		}
+
+		var after StreamID
+		if opts.ids[i] == "$" {
+			if st != nil {
+				after = st.LastID
+			}
+			retry[idsAt+i] = after.String()
+		} else if after, err = parseStreamID(opts.ids[i], 0); err != nil {
+			return []byte(""), err
+		}
+
+		var entries []StreamEntry
+		if start, ok := after.next(); ok && st != nil {
+			entries = st.between(start, maxStreamID, opts.count, false)
+		}
+		replies[i], empty[i] = entriesReply(entries), len(entries) == 0
+		found = found || len(entries) > 0
+	}
+
+	if found {
+		return streamsReply(opts.keys, replies, empty), nil
+	}
+	if opts.blocking && c.canBlock {
+		return []byte(""), &blockedError{keys: opts.keys, timeout: opts.block, retry: retry}
+	}
+	return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+}
+
+// Handles the parameters for XREADGROUP command
+func (c *Context) xReadGroup(params []string) ([]byte, error) {
+	//GROUP group consumer [COUNT count] [BLOCK ms] [NOACK] STREAMS key [key ...] id|> [id|> ...]
+	opts, err := parseReadArgs(params, true)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	//check every stream before delivering from any of them
+	ids := make([]StreamID, len(opts.ids))
+	for i, key := range opts.keys {
+		_, st, err := c.db.getSegment(key).lookupStream(key)
+		if err != nil {
+			return []byte(""), err
+		}
+		if st == nil || st.Groups[opts.group] == nil {
+			return []byte(""), fmt.Errorf("-NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option\r\n", key, opts.group)
+		}
+		if opts.ids[i] != ">" {
+			if ids[i], err = parseStreamID(opts.ids[i], 0); err != nil {
+				return []byte(""), err
+			}
+		}
+	}
+
+	replies := make([][]byte, len(opts.keys))
+	empty := make([]bool, len(opts.keys))
+	found, onlyNew := false, true
+	now := nowMs()
+	for i, key := range opts.keys {
+		seg := c.db.getSegment(key)
+		kv, st, _ := seg.lookupStream(key)
+		group := st.Groups[opts.group]
+
+		if opts.ids[i] != ">" {
+			//the consumer's own history, which is never empty in the reply
+			onlyNew = false
+			replies[i], found = c.pendingHistory(st, group, opts, ids[i]), true
+			continue
+		}
+
+		var entries []StreamEntry
+		if start, ok := group.LastDelivered.next(); ok {
+			entries = st.between(start, maxStreamID, opts.count, false)
+		}
+		replies[i], empty[i] = entriesReply(entries), len(entries) == 0
+		found = found || len(entries) > 0
+
+		//record the deliveries, or at least the new consumer
+		if _, known := group.Consumers[opts.consumer]; len(entries) > 0 || !known {
+			noAck := "0"
+			if opts.noAck {
+				noAck = "1"
+			}
+			args := []string{opts.group, opts.consumer, noAck}
+			for _, entry := range entries {
+				args = append(args, entry.ID.String())
+			}
+			record := WALRecord{Command: "XREADGROUP", Args: args, Offset: now}
+			st.apply(record)
+			c.putStream(seg, key, kv, record)
+		}
+	}
+
+	if found {
+		return streamsReply(opts.keys, replies, empty), nil
+	}
+	if onlyNew && opts.blocking && c.canBlock {
+		return []byte(""), &blockedError{keys: opts.keys, timeout: opts.block, retry: params}
+	}
+	return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), nil
+}
+
+// pendingHistory encodes the entries pending for the consumer after the
+// given ID; entries trimmed since they were delivered have no fields
+func (c *Context) pendingHistory(st *Stream, group *ConsumerGroup, opts readOptions, after StreamID) []byte {
+	response := []byte{}
+	n := 0
+	for _, id := range group.sortedPending() {
+		if !after.less(id) || group.Pending[id].Consumer != opts.consumer {
+			continue
+		}
+		if opts.count > 0 && n == opts.count {
+			break
+		}
+		if entry, exists := st.entry(id); exists {
+			response = append(response, entryReply(entry)...)
+		} else {
+			response = append(response, []byte(fmt.Sprintf("*2\r\n+%s\r\n+%s\r\n", id, "(nil)"))...)
+		}
+		n++
+	}
+	return append([]byte(fmt.Sprintf("*%d\r\n", n)), response...)
+}
+
+// Handles the parameters for XGROUP command
+func (c *Context) xGroup(params []string) ([]byte, error) {
+	//CREATE key group id|$ [MKSTREAM] [ENTRIESREAD n] | SETID key group id|$ [ENTRIESREAD n] |
+	//DESTROY key group | CREATECONSUMER key group consumer | DELCONSUMER key group consumer
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'xgroup' command\r\n")
+	}
+
+	subcommand, key, name := strings.ToUpper(params[0]), params[1], params[2]
+	seg := c.db.getSegment(key)
+	kv, st, err := seg.lookupStream(key)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	switch subcommand {
+	case "CREATE", "SETID":
+		if len(params) < 4 {
+			return []byte(""), fmt.Errorf("-ERR wrong number of arguments for 'xgroup|%s' command\r\n", strings.ToLower(subcommand))
+		}
+		mkStream := false
+		for i := 4; i < len(params); i++ {
+			switch option := strings.ToUpper(params[i]); {
+			case option == "MKSTREAM" && subcommand == "CREATE":
+				mkStream = true
+			case option == "ENTRIESREAD" && i+1 < len(params):
+				i++
+			default:
+				return []byte(""), errors.New("-ERR syntax error\r\n")
+			}
+		}
+
+		if st == nil {
+			if !mkStream {
+				return []byte(""), errors.New("-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n")
+			}
+			st = newStream()
+			kv = KeyValue{Stream: st}
+			c.Put(key, kv)
+		}
+
+		_, exists := st.Groups[name]
+		if subcommand == "CREATE" && exists {
+			return []byte(""), errors.New("-BUSYGROUP Consumer Group name already exists\r\n")
+		}
+		if subcommand == "SETID" && !exists {
+			return []byte(""), fmt.Errorf("-NOGROUP No such consumer group '%s' for key name '%s'\r\n", name, key)
+		}
+
+		id := st.LastID
+		if params[3] != "$" {
+			if id, err = parseStreamID(params[3], 0); err != nil {
+				return []byte(""), err
+			}
+		}
+		record := WALRecord{Command: "XGROUP", Args: []string{subcommand, name, id.String()}}
+		st.apply(record)
+		c.putStream(seg, key, kv, record)
+		return []byte("+OK\r\n"), nil
+
+	case "DESTROY":
+		if st == nil || st.Groups[name] == nil {
+			return []byte(":0\r\n"), nil
+		}
+		record := WALRecord{Command: "XGROUP", Args: []string{subcommand, name}}
+		st.apply(record)
+		c.putStream(seg, key, kv, record)
+		return []byte(":1\r\n"), nil
+
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(params) != 4 {
+			return []byte(""), fmt.Errorf("-ERR wrong number of arguments for 'xgroup|%s' command\r\n", strings.ToLower(subcommand))
+		}
+		if st == nil {
+			return []byte(""), errors.New("-ERR no such key\r\n")
+		}
+		group := st.Groups[name]
+		if group == nil {
+			return []byte(""), fmt.Errorf("-NOGROUP No such consumer group '%s' for key name '%s'\r\n", name, key)
+		}
+
+		consumer := params[3]
+		_, known := group.Consumers[consumer]
+		pending := 0
+		for _, entry := range group.Pending {
+			if entry.Consumer == consumer {
+				pending++
+			}
+		}
+		if (subcommand == "CREATECONSUMER") == known {
+			//nothing to create, or nothing to delete
+			return []byte(":0\r\n"), nil
+		}
+
+		record := WALRecord{Command: "XGROUP", Args: []string{subcommand, name, consumer}, Offset: nowMs()}
+		st.apply(record)
+		c.putStream(seg, key, kv, record)
+		if subcommand == "CREATECONSUMER" {
+			return []byte(":1\r\n"), nil
+		}
+		return []byte(fmt.Sprintf(":%d\r\n", pending)), nil
+	}
+	return []byte(""), fmt.Errorf("-ERR unknown subcommand '%s'. Try XGROUP CREATE, SETID, DESTROY, CREATECONSUMER or DELCONSUMER.\r\n", params[0])
+}
+
+// streamGroup returns the stream of a key and one of its consumer groups
+func (seg *segment) streamGroup(key string, name string) (KeyValue, *Stream, *ConsumerGroup, error) {
+	kv, st, err := seg.lookupStream(key)
+	if err != nil {
+		return kv, nil, nil, err
+	}
+	if st == nil || st.Groups[name] == nil {
+		return kv, nil, nil, fmt.Errorf("-NOGROUP No such key '%s' or consumer group '%s'\r\n", key, name)
+	}
+	return kv, st, st.Groups[name], nil
+}
+
+// Handles the parameters for XACK command
+func (c *Context) xAck(params []string) ([]byte, error) {
+	//KEY GROUP ID [ID ...]
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'xack' command\r\n")
+	}
+
+	ids := make([]StreamID, 0, len(params)-2)
+	for _, arg := range params[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return []byte(""), err
+		}
+		ids = append(ids, id)
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+	kv, st, err := seg.lookupStream(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if st == nil || st.Groups[params[1]] == nil {
+		return []byte(":0\r\n"), nil
+	}
+	group := st.Groups[params[1]]
+
+	args := []string{params[1]}
+	for _, id := range ids {
+		if _, pending := group.Pending[id]; pending {
+			args = append(args, id.String())
+		}
+	}
+	if len(args) > 1 {
+		record := WALRecord{Command: "XACK", Args: args}
+		st.apply(record)
+		c.putStream(seg, key, kv, record)
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", len(args)-1)), nil
+}
+
+// Handles the parameters for XPENDING command
+func (c *Context) xPending(params []string) ([]byte, error) {
+	//KEY GROUP [[IDLE min-idle-time] start end count [consumer]]
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'xpending' command\r\n")
+	}
+
+	_, _, group, err := c.db.getSegment(params[0]).streamGroup(params[0], params[1])
+	if err != nil {
+		return []byte(""), err
+	}
+	ids := group.sortedPending()
+
+	//summary: count, lowest and highest ID, and the count of every consumer
+	if len(params) == 2 {
+		if len(ids) == 0 {
+			return []byte(fmt.Sprintf("*4\r\n:0\r\n+%s\r\n+%s\r\n+%s\r\n", "(nil)", "(nil)", "(nil)")), nil
+		}
+		perConsumer := make(map[string]int)
+		consumers := []string{}
+		for _, id := range ids {
+			consumer := group.Pending[id].Consumer
+			if perConsumer[consumer] == 0 {
+				consumers = append(consumers, consumer)
+			}
+			perConsumer[consumer]++
+		}
+		response := []byte(fmt.Sprintf("*4\r\n:%d\r\n+%s\r\n+%s\r\n*%d\r\n", len(ids), ids[0], ids[len(ids)-1], len(consumers)))
+		for _, consumer := range consumers {
+			response = append(response, []byte(fmt.Sprintf("*2\r\n+%s\r\n+%d\r\n", consumer, perConsumer[consumer]))...)
+		}
+		return response, nil
+	}
+
+	rest := params[2:]
+	minIdle := int64(0)
+	if strings.ToUpper(rest[0]) == "IDLE" {
+		if len(rest) < 2 {
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+		if minIdle, err = strconv.ParseInt(rest[1], 10, 64); err != nil {
+			return []byte(""), errors.New("-ERR value is not an integer or out of range\r\n")
+		}
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		return []byte(""), errors.New("-ERR syntax error\r\n")
+	}
+
+	start, startOk, err := parseRangeID(rest[0], true)
+	if err != nil {
+		return []byte(""), err
+	}
+	end, endOk, err := parseRangeID(rest[1], false)
+	if err != nil {
+		return []byte(""), err
+	}
+	count, err := strconv.Atoi(rest[2])
+	if err != nil {
+		return []byte(""), errors.New("-ERR value is not an integer or out of range\r\n")
+	}
+	consumer := ""
+	if len(rest) == 4 {
+		consumer = rest[3]
+	}
+
+	now := nowMs()
+	response := []byte{}
+	n := 0
+	for _, id := range ids {
+		if !startOk || !endOk || n >= count {
+			break
+		}
+		pending := group.Pending[id]
+		idle := now - pending.DeliveredAt
+		if id.less(start) || end.less(id) || idle < minIdle || (consumer != "" && pending.Consumer != consumer) {
+			continue
+		}
+		response = append(response, []byte(fmt.Sprintf("*4\r\n+%s\r\n+%s\r\n:%d\r\n:%d\r\n", id, pending.Consumer, idle, pending.Deliveries))...)
+		n++
+	}
+	return append([]byte(fmt.Sprintf("*%d\r\n", n)), response...), nil
+}
+
+// claim hands pending entries idle for at least minIdle milliseconds over to
+// consumer. Entries trimmed from the stream are acknowledged instead and
+// returned separately.
+func (c *Context) claim(seg *segment, key string, kv KeyValue, group string, consumer string, ids []StreamID, minIdle int64, justID bool) ([]StreamEntry, []StreamID) {
+	st := kv.Stream
+	pending := st.Groups[group].Pending
+	now := nowMs()
+
+	claimed, deleted := []StreamEntry{}, []StreamID{}
+	claimArgs, ackArgs := []string{group, consumer, "0"}, []string{group}
+	if justID {
+		claimArgs[2] = "1"
+	}
+	for _, id := range ids {
+		entry, exists := pending[id]
+		if !exists {
+			continue
+		}
+		if streamEntry, found := st.entry(id); !found {
+			deleted = append(deleted, id)
+			ackArgs = append(ackArgs, id.String())
+		} else if now-entry.DeliveredAt >= minIdle {
+			claimed = append(claimed, streamEntry)
+			claimArgs = append(claimArgs, id.String())
+		}
+	}
+
+	records := []WALRecord{}
+	if len(claimArgs) > 3 {
+		records = append(records, WALRecord{Command: "XCLAIM", Args: claimArgs, Offset: now})
+	}
+	if len(ackArgs) > 1 {
+		records = append(records, WALRecord{Command: "XACK", Args: ackArgs})
+	}
+	if len(records) > 0 {
+		for _, record := range records {
+			st.apply(record)
+		}
+		c.putStream(seg, key, kv, records...)
+	}
+	return claimed, deleted
+}
+
+// Handles the parameters for XCLAIM command
+func (c *Context) xClaim(params []string) ([]byte, error) {
+	//KEY GROUP CONSUMER MIN-IDLE-TIME ID [ID ...] [JUSTID]
+	if len(params) < 5 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'xclaim' command\r\n")
+	}
+
+	minIdle, err := strconv.ParseInt(params[3], 10, 64)
+	if err != nil || minIdle < 0 {
+		return []byte(""), errors.New("-ERR Invalid min-idle-time argument for XCLAIM\r\n")
+	}
+
+	ids := []StreamID{}
+	justID := false
+	for _, arg := range params[4:] {
+		if strings.ToUpper(arg) == "JUSTID" {
+			justID = true
+			continue
+		}
+		if justID {
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return []byte(""), err
+		}
+		ids = append(ids, id)
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+	kv, _, _, err := seg.streamGroup(key, params[1])
+	if err != nil {
+		return []byte(""), err
+	}
+
+	claimed, _ := c.claim(seg, key, kv, params[1], params[2], ids, minIdle, justID)
+	if justID {
+		claimedIDs := make([]StreamID, len(claimed))
+		for i, entry := range claimed {
+			claimedIDs[i] = entry.ID
+		}
+		return idsReply(claimedIDs), nil
+	}
+	return entriesReply(claimed), nil
+}
+
+// Handles the parameters for XAUTOCLAIM command
+func (c *Context) xAutoClaim(params []string) ([]byte, error) {
+	//KEY GROUP CONSUMER MIN-IDLE-TIME START [COUNT count] [JUSTID]
+	if len(params) < 5 {
+		return []byte(""), errors.New("-ERR wrong number of arguments for 'xautoclaim' command\r\n")
+	}
+
+	minIdle, err := strconv.ParseInt(params[3], 10, 64)
+	if err != nil || minIdle < 0 {
+		return []byte(""), errors.New("-ERR Invalid min-idle-time argument for XAUTOCLAIM\r\n")
+	}
+	start, _, err := parseRangeID(params[4], true)
+	if err != nil {
+		return []byte(""), err
+	}
+
+	count, justID := 100, false
+	for i := 5; i < len(params); i++ {
+		switch strings.ToUpper(params[i]) {
+		case "COUNT":
+			if i+1 >= len(params) {
+				return []byte(""), errors.New("-ERR syntax error\r\n")
+			}
+			if count, err = strconv.Atoi(params[i+1]); err != nil || count < 1 {
+				return []byte(""), errors.New("-ERR COUNT must be > 0\r\n")
+			}
+			i++
+		case "JUSTID":
+			justID = true
+		default:
+			return []byte(""), errors.New("-ERR syntax error\r\n")
+		}
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+	kv, _, group, err := seg.streamGroup(key, params[1])
+	if err != nil {
+		return []byte(""), err
+	}
+
+	//examine up to count pending entries from start, the cursor being the next one
+	ids := group.sortedPending()
+	from := 0
+	for from < len(ids) && ids[from].less(start) {
+		from++
+	}
+	to := from + count
+	cursor := StreamID{}
+	if to < len(ids) {
+		cursor = ids[to]
+	} else {
+		to = len(ids)
+	}
+
+	claimed, deleted := c.claim(seg, key, kv, params[1], params[2], ids[from:to], minIdle, justID)
+
+	response := []byte(fmt.Sprintf("*3\r\n+%s\r\n", cursor))
+	if justID {
+		claimedIDs := make([]StreamID, len(claimed))
+		for i, entry := range claimed {
+			claimedIDs[i] = entry.ID
+		}
+		response = append(response, idsReply(claimedIDs)...)
+	} else {
+		response = append(response, entriesReply(claimed)...)
+	}
+	return append(response, idsReply(deleted)...), nil
+}
@@ -0,0 +1,450 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// maxStringLength bounds how far SETRANGE and SETBIT may grow a value (512MB)
+const maxStringLength = 512 * 1024 * 1024
+
+// Handles the parameters for APPEND command
+func (c *Context) appendValue(params []string) ([]byte, error) {
+	//KEY VALUE
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR APPEND command requires key and value\r\n")
+	}
+
+	key, suffix := params[0], []byte(params[1])
+	seg := c.db.getSegment(key)
+
+	kv, exists, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	kv.Value = append(kv.Value, suffix...)
+	version := c.put(seg, key, kv)
+
+	//only the appended bytes are logged
+	c.logStringWrite(key, kv, exists, WALRecord{Command: "APPEND", Key: key, Value: suffix, Version: version})
+
+	return []byte(fmt.Sprintf(":%d\r\n", len(kv.Value))), nil
+}
+
+// logStringWrite logs a change made in place to a string. A key that did not
+// exist is logged whole as a SET instead, as the log may still hold an
+// expired value under it that replaying the change alone would build on.
+func (c *Context) logStringWrite(key string, kv KeyValue, exists bool, change WALRecord) {
+	if exists {
+		c.log(change)
+		return
+	}
+	//the value may be changed in place before the record is written
+	value := append([]byte(nil), kv.Value...)
+	c.log(WALRecord{Command: "SET", Key: key, Value: value, Version: change.Version})
+}
+
+// Handles the parameters for STRLEN command
+func (c *Context) strLen(params []string) ([]byte, error) {
+	//KEY
+	if len(params) < 1 {
+		return []byte(""), errors.New("-ERR STRLEN command requires a key\r\n")
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+
+	kv, _, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", len(kv.Value))), nil
+}
+
+// Handles the parameters for GETRANGE command
+func (c *Context) getRange(params []string) ([]byte, error) {
+	//KEY START END
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR GETRANGE command requires key, start and end\r\n")
+	}
+
+	start, errStart := strconv.ParseInt(params[1], 10, 64)
+	end, errEnd := strconv.ParseInt(params[2], 10, 64)
+	if errStart != nil || errEnd != nil {
+		return []byte(""), errors.New("-ERR value is not an integer or out of range\r\n")
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+
+	kv, _, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	from, to, ok := normalizeRange(start, end, int64(len(kv.Value)))
+	if !ok {
+		return []byte("+\r\n"), nil
+	}
+	return []byte(fmt.Sprintf("+%s\r\n", kv.Value[from:to+1])), nil
+}
+
+// Handles the parameters for SETRANGE command
+func (c *Context) setRange(params []string) ([]byte, error) {
+	//KEY OFFSET VALUE
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR SETRANGE command requires key, offset and value\r\n")
+	}
+
+	offset, err := strconv.ParseInt(params[1], 10, 64)
+	if err != nil || offset < 0 {
+		return []byte(""), errors.New("-ERR offset is out of range\r\n")
+	}
+	patch := []byte(params[2])
+	if offset+int64(len(patch)) > maxStringLength {
+		return []byte(""), errors.New("-ERR string exceeds maximum allowed size\r\n")
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+
+	kv, exists, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if len(patch) == 0 {
+		//nothing to write, and a missing key is not created
+		return []byte(fmt.Sprintf(":%d\r\n", len(kv.Value))), nil
+	}
+
+	kv.Value = writeRange(kv.Value, offset, patch)
+	if !exists {
+		kv.ExpireAt = 0
+	}
+	version := c.put(seg, key, kv)
+	c.logStringWrite(key, kv, exists, WALRecord{Command: "SETRANGE", Key: key, Value: patch, Offset: offset, Version: version})
+
+	return []byte(fmt.Sprintf(":%d\r\n", len(kv.Value))), nil
+}
+
+// Handles the parameters for SETBIT command
+func (c *Context) setBit(params []string) ([]byte, error) {
+	//KEY OFFSET 0|1
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR SETBIT command requires key, offset and value\r\n")
+	}
+
+	offset, err := strconv.ParseInt(params[1], 10, 64)
+	if err != nil || offset < 0 || offset >= maxStringLength*8 {
+		return []byte(""), errors.New("-ERR bit offset is not an integer or out of range\r\n")
+	}
+	if params[2] != "0" && params[2] != "1" {
+		return []byte(""), errors.New("-ERR bit is not an integer or out of range\r\n")
+	}
+	bit := params[2][0] - '0'
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+
+	kv, exists, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if !exists {
+		kv.ExpireAt = 0
+	}
+
+	var original byte
+	kv.Value, original = writeBit(kv.Value, offset, bit)
+	version := c.put(seg, key, kv)
+	c.logStringWrite(key, kv, exists, WALRecord{Command: "SETBIT", Key: key, Value: []byte{bit}, Offset: offset, Version: version})
+
+	return []byte(fmt.Sprintf(":%d\r\n", original)), nil
+}
+
+// Handles the parameters for GETBIT command
+func (c *Context) getBit(params []string) ([]byte, error) {
+	//KEY OFFSET
+	if len(params) < 2 {
+		return []byte(""), errors.New("-ERR GETBIT command requires key and offset\r\n")
+	}
+
+	offset, err := strconv.ParseInt(params[1], 10, 64)
+	if err != nil || offset < 0 {
+		return []byte(""), errors.New("-ERR bit offset is not an integer or out of range\r\n")
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+
+	kv, _, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", readBit(kv.Value, offset))), nil
+}
+
+// Handles the parameters for BITCOUNT command
+func (c *Context) bitCount(params []string) ([]byte, error) {
+	//KEY [START END [BYTE|BIT]]
+	if len(params) != 1 && len(params) != 3 && len(params) != 4 {
+		return []byte(""), errors.New("-ERR syntax error\r\n")
+	}
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+
+	kv, _, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	from, to, inBits, ok, err := parseBitRange(params[1:], int64(len(kv.Value)))
+	if err != nil {
+		return []byte(""), err
+	}
+	if !ok {
+		return []byte(":0\r\n"), nil
+	}
+
+	count := 0
+	if inBits {
+		for i := from; i <= to; i++ {
+			count += int(readBit(kv.Value, i))
+		}
+	} else {
+		for _, b := range kv.Value[from : to+1] {
+			count += bits.OnesCount8(b)
+		}
+	}
+
+	return []byte(fmt.Sprintf(":%d\r\n", count)), nil
+}
+
+// Handles the parameters for BITPOS command
+func (c *Context) bitPos(params []string) ([]byte, error) {
+	//KEY BIT [START [END [BYTE|BIT]]]
+	if len(params) < 2 || len(params) > 5 {
+		return []byte(""), errors.New("-ERR syntax error\r\n")
+	}
+	if params[1] != "0" && params[1] != "1" {
+		return []byte(""), errors.New("-ERR The bit argument must be 1 or 0.\r\n")
+	}
+	bit := params[1][0] - '0'
+
+	key := params[0]
+	seg := c.db.getSegment(key)
+
+	kv, exists, err := seg.lookupString(key)
+	if err != nil {
+		return []byte(""), err
+	}
+	if !exists {
+		//a missing key is an empty string of zero bits
+		if bit == 0 {
+			return []byte(":0\r\n"), nil
+		}
+		return []byte(":-1\r\n"), nil
+	}
+
+	length := int64(len(kv.Value))
+	rangeArgs := params[2:]
+	endGiven := len(rangeArgs) >= 2
+	if len(rangeArgs) == 1 {
+		//only START given, the range runs to the end of the string
+		rangeArgs = []string{rangeArgs[0], "-1"}
+	}
+
+	from, to, inBits, ok, err := parseBitRange(rangeArgs, length)
+	if err != nil {
+		return []byte(""), err
+	}
+	if !ok {
+		return []byte(":-1\r\n"), nil
+	}
+	if !inBits {
+		from, to = from*8, to*8+7
+	}
+
+	for i := from; i <= to; i++ {
+		if readBit(kv.Value, i) == bit {
+			return []byte(fmt.Sprintf(":%d\r\n", i)), nil
+		}
+	}
+
+	//looking for a clear bit past a fully set string finds the first padding bit,
+	//unless the caller bounded the search
+	if bit == 0 && !endGiven {
+		return []byte(fmt.Sprintf(":%d\r\n", length*8)), nil
+	}
+	return []byte(":-1\r\n"), nil
+}
+
+// Handles the parameters for BITOP command
+func (c *Context) bitOp(params []string) ([]byte, error) {
+	//AND|OR|XOR|NOT DESTKEY KEY [KEY ...]
+	if len(params) < 3 {
+		return []byte(""), errors.New("-ERR BITOP command requires an operation, destination and source keys\r\n")
+	}
+
+	op := strings.ToUpper(params[0])
+	dest, sources := params[1], params[2:]
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(sources) != 1 {
+			return []byte(""), errors.New("-ERR BITOP NOT must be called with a single source key.\r\n")
+		}
+	default:
+		return []byte(""), errors.New("-ERR syntax error\r\n")
+	}
+
+	values := make([][]byte, len(sources))
+	maxLen := 0
+	for i, key := range sources {
+		kv, _, err := c.db.getSegment(key).lookupString(key)
+		if err != nil {
+			return []byte(""), err
+		}
+		values[i] = kv.Value
+		if len(kv.Value) > maxLen {
+			maxLen = len(kv.Value)
+		}
+	}
+
+	//missing keys and shorter strings are treated as zero bytes
+	result := make([]byte, maxLen)
+	for i := range result {
+		var b byte
+		for j, value := range values {
+			var v byte
+			if i < len(value) {
+				v = value[i]
+			}
+			switch {
+			case op == "NOT":
+				b = ^v
+			case j == 0:
+				b = v
+			case op == "AND":
+				b &= v
+			case op == "OR":
+				b |= v
+			case op == "XOR":
+				b ^= v
+			}
+		}
+		result[i] = b
+	}
+
+	destSeg := c.db.getSegment(dest)
+	if len(result) == 0 {
+		if _, exists := destSeg.lookup(dest); exists {
+			delete(destSeg.kv, dest)
+			c.log(WALRecord{Command: "DEL", Key: dest})
+		}
+		return []byte(":0\r\n"), nil
+	}
+
+	c.setValue(destSeg, dest, result, 0)
+	return []byte(fmt.Sprintf(":%d\r\n", len(result))), nil
+}
+
+// normalizeRange resolves inclusive, possibly negative, start and end indexes
+// against a length, reporting false when the range is empty
+func normalizeRange(start, end, length int64) (int64, int64, bool) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	if length == 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// parseBitRange parses the optional START END [BYTE|BIT] arguments of the bit
+// commands, resolving them against a string of length bytes. Without
+// arguments the whole string is selected.
+func parseBitRange(args []string, length int64) (from, to int64, inBits, ok bool, err error) {
+	if len(args) == 0 {
+		from, to, ok = normalizeRange(0, -1, length)
+		return from, to, false, ok, nil
+	}
+
+	start, errStart := strconv.ParseInt(args[0], 10, 64)
+	end, errEnd := strconv.ParseInt(args[1], 10, 64)
+	if errStart != nil || errEnd != nil {
+		return 0, 0, false, false, errors.New("-ERR value is not an integer or out of range\r\n")
+	}
+
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			inBits = true
+		default:
+			return 0, 0, false, false, errors.New("-ERR syntax error\r\n")
+		}
+	}
+
+	if inBits {
+		from, to, ok = normalizeRange(start, end, length*8)
+	} else {
+		from, to, ok = normalizeRange(start, end, length)
+	}
+	return from, to, inBits, ok, nil
+}
+
+// writeRange overwrites value at offset with patch, zero padding the value
+// as needed, and returns the (possibly reallocated) value
+func writeRange(value []byte, offset int64, patch []byte) []byte {
+	if need := offset + int64(len(patch)); need > int64(len(value)) {
+		value = append(value, make([]byte, need-int64(len(value)))...)
+	}
+	copy(value[offset:], patch)
+	return value
+}
+
+// writeBit sets the bit at offset (most significant bit first) to bit, growing
+// the value as needed, and returns the value along with the bit's old state
+func writeBit(value []byte, offset int64, bit byte) ([]byte, byte) {
+	index := offset / 8
+	if index >= int64(len(value)) {
+		value = append(value, make([]byte, index+1-int64(len(value)))...)
+	}
+
+	mask := byte(0x80) >> (offset % 8)
+	original := byte(0)
+	if value[index]&mask != 0 {
+		original = 1
+	}
+	if bit == 1 {
+		value[index] |= mask
+	} else {
+		value[index] &^= mask
+	}
+	return value, original
+}
+
+// readBit returns the bit at offset (most significant bit first), bits past
+// the end of the value reading as 0
+func readBit(value []byte, offset int64) byte {
+	index := offset / 8
+	if index >= int64(len(value)) {
+		return 0
+	}
+	if value[index]&(byte(0x80)>>(offset%8)) != 0 {
+		return 1
+	}
+	return 0
+}
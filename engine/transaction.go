@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"tempDB/utils"
+	"time"
+)
+
+// Exec runs queued commands as one transaction. Every segment touched by the
+// commands or the watched keys is locked (in order) for the whole run, the
+// watched versions are checked first, and the writes of all the commands go
+// to the WAL as a single batch. If a watched key changed, nothing runs and a
+// null reply is returned. Commands called from scripts are checked with
+// authorize unless it is nil.
+func (db *Store) Exec(commands []utils.Request, watched map[string]uint64, authorize Authorizer) ([]byte, error) {
+	watchedKeys := make([]string, 0, len(watched))
+	for key := range watched {
+		watchedKeys = append(watchedKeys, key)
+	}
+
+	start := time.Now()
+	defer func() { observeCommand("EXEC", time.Since(start)) }()
+
+	unlock, allLocked := db.lockFor(commands, watchedKeys)
+	defer unlock()
+
+	for key, version := range watched {
+		if db.getSegment(key).version(key) != version {
+			return []byte("*-1\r\n"), nil
+		}
+	}
+
+	c := &Context{db: db, allLocked: allLocked, authorize: authorize}
+	response := []byte(fmt.Sprintf("*%d\r\n", len(commands)))
+	for _, command := range commands {
+		//a failing command does not stop the ones after it
+		reply, err := c.dispatch(command)
+		if err != nil {
+			reply = ErrorReply(err)
+		}
+		response = append(response, reply...)
+	}
+
+	c.flush()
+	return response, nil
+}
+
+// Versions returns the current version of each key, 0 for a missing key,
+// for WATCH to compare against when the transaction executes
+func (db *Store) Versions(keys []string) map[string]uint64 {
+	unlock := db.lockSegments(keys, false)
+	defer unlock()
+
+	versions := make(map[string]uint64, len(keys))
+	for _, key := range keys {
+		versions[key] = db.getSegment(key).version(key)
+	}
+	return versions
+}
+
+// version returns the version of a live key, or 0 if it does not exist
+func (seg *segment) version(key string) uint64 {
+	kv, exists := seg.lookup(key)
+	if !exists {
+		return 0
+	}
+	return kv.Version
+}
+
+// ErrorReply encodes a command error as a RESP error reply
+func ErrorReply(err error) []byte {
+	msg := strings.TrimRight(err.Error(), "\r\n")
+	if !strings.HasPrefix(msg, "-") {
+		msg = "-ERR " + msg
+	}
+	return []byte(msg + "\r\n")
+}
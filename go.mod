@@ -4,5 +4,6 @@ go 1.23
 
 require (
     github.com/joho/godotenv v1.5.1
+    github.com/yuin/gopher-lua v1.1.1
     gopkg.in/yaml.v3 v3.0.1
 )
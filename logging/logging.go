@@ -0,0 +1,80 @@
+// Package logging builds the structured logger the server, engine and
+// persistence log through, from the log section of the configuration.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"tempDB/config"
+)
+
+var (
+	instance *slog.Logger
+	once     sync.Once
+)
+
+// Logger returns the singleton logger, built from the configuration on first use
+func Logger() *slog.Logger {
+	once.Do(func() {
+		logger, err := New(config.GetLogConfig())
+		if err != nil {
+			panic(err)
+		}
+		instance = logger
+	})
+	return instance
+}
+
+// For returns the logger of a component, whose records carry its name
+func For(component string) *slog.Logger {
+	return Logger().With("component", component)
+}
+
+// New builds a logger writing records at or above the configured level, as
+// text or JSON, to the log file or stdout
+func New(cfg *config.LogConfig) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", cfg.Level)
+	}
+
+	var out io.Writer = os.Stdout
+	if cfg.File != "" {
+		file, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = file
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(cfg.Format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(out, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(out, options)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q, expected text or json", cfg.Format)
+}
+
+// Values returns command arguments, or a reply, to log as they are when
+// log_values is set, and otherwise only how many there are, so the data
+// stored never reaches the logs
+func Values(values []string) slog.Value {
+	if config.GetLogConfig().LogValues {
+		return slog.AnyValue(values)
+	}
+	return slog.StringValue(fmt.Sprintf("(%d redacted)", len(values)))
+}
+
+// Reply returns a reply to log, or only its size unless log_values is set
+func Reply(reply []byte) slog.Value {
+	if config.GetLogConfig().LogValues {
+		return slog.StringValue(string(reply))
+	}
+	return slog.StringValue(fmt.Sprintf("(%d bytes redacted)", len(reply)))
+}
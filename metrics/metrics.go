@@ -0,0 +1,300 @@
+// Package metrics keeps the counters, gauges and histograms the server
+// exposes, and writes them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is anything the registry can write
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = make(map[string]metric)
+	scrapeHooks   []func()
+)
+
+// register adds a metric, panicking on a duplicate name as that is a bug
+func register(m metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	if _, exists := registry[m.name()]; exists {
+		panic(fmt.Sprintf("metric %s registered twice", m.name()))
+	}
+	registry[m.name()] = m
+}
+
+// OnScrape registers fn to run before every scrape, to gather at once values
+// several metrics read
+func OnScrape(fn func()) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	scrapeHooks = append(scrapeHooks, fn)
+}
+
+// WriteTo writes every metric, sorted by name
+func WriteTo(w io.Writer) {
+	registryMutex.RLock()
+	hooks := scrapeHooks
+	metrics := make([]metric, 0, len(registry))
+	for _, m := range registry {
+		metrics = append(metrics, m)
+	}
+	registryMutex.RUnlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name() < metrics[j].name() })
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// header writes the HELP and TYPE lines of a metric
+func header(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// float is a float64 updated atomically
+type float struct {
+	bits atomic.Uint64
+}
+
+func (f *float) add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *float) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeLabel escapes a label value for the exposition format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Counter only goes up
+type Counter struct {
+	metricName, help string
+	value            float
+}
+
+// NewCounter registers a counter
+func NewCounter(name, help string) *Counter {
+	c := &Counter{metricName: name, help: help}
+	register(c)
+	return c
+}
+
+func (c *Counter) Inc()              { c.value.add(1) }
+func (c *Counter) Add(delta float64) { c.value.add(delta) }
+func (c *Counter) name() string      { return c.metricName }
+
+func (c *Counter) write(w io.Writer) {
+	header(w, c.metricName, c.help, "counter")
+	fmt.Fprintf(w, "%s %s\n", c.metricName, formatFloat(c.value.load()))
+}
+
+// CounterVec is a counter per value of one label
+type CounterVec struct {
+	metricName, help, label string
+	mutex                   sync.RWMutex
+	counters                map[string]*float
+}
+
+// NewCounterVec registers a counter with one label
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{metricName: name, help: help, label: label, counters: make(map[string]*float)}
+	register(c)
+	return c
+}
+
+// Add adds delta to the counter of a label value
+func (c *CounterVec) Add(value string, delta float64) {
+	c.mutex.RLock()
+	counter, exists := c.counters[value]
+	c.mutex.RUnlock()
+	if !exists {
+		c.mutex.Lock()
+		if counter, exists = c.counters[value]; !exists {
+			counter = &float{}
+			c.counters[value] = counter
+		}
+		c.mutex.Unlock()
+	}
+	counter.add(delta)
+}
+
+func (c *CounterVec) Inc(value string) { c.Add(value, 1) }
+func (c *CounterVec) name() string     { return c.metricName }
+
+func (c *CounterVec) write(w io.Writer) {
+	header(w, c.metricName, c.help, "counter")
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, value := range sortedKeys(c.counters) {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", c.metricName, c.label, escapeLabel(value), formatFloat(c.counters[value].load()))
+	}
+}
+
+// funcMetric is a counter or gauge read from fn when scraped, for values
+// kept elsewhere
+type funcMetric struct {
+	metricName, help, kind string
+	fn                     func() float64
+}
+
+// NewCounterFunc registers a counter whose value fn returns
+func NewCounterFunc(name, help string, fn func() float64) {
+	register(&funcMetric{metricName: name, help: help, kind: "counter", fn: fn})
+}
+
+// NewGaugeFunc registers a gauge whose value fn returns
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(&funcMetric{metricName: name, help: help, kind: "gauge", fn: fn})
+}
+
+func (f *funcMetric) name() string { return f.metricName }
+
+func (f *funcMetric) write(w io.Writer) {
+	header(w, f.metricName, f.help, f.kind)
+	fmt.Fprintf(w, "%s %s\n", f.metricName, formatFloat(f.fn()))
+}
+
+// DefBuckets are latency buckets in seconds, from 10µs to 10s
+var DefBuckets = []float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64 // per bucket, not cumulative, the last one is +Inf
+	sum     float
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.counts[i].Add(1)
+	h.sum.add(v)
+}
+
+// write writes the series of the histogram, with labels (such as
+// `command="get",`) put before the le label
+func (h *histogram) write(w io.Writer, name, labels string) {
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	cumulative += h.counts[len(h.buckets)].Load()
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, cumulative)
+
+	labels = strings.TrimSuffix(labels, ",")
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, labels, formatFloat(h.sum.load()), name, labels, cumulative)
+}
+
+// Histogram counts observations, typically durations in seconds, in buckets
+type Histogram struct {
+	metricName, help string
+	h                *histogram
+}
+
+// NewHistogram registers a histogram with the given upper bounds, sorted
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{metricName: name, help: help, h: newHistogram(buckets)}
+	register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64) { h.h.observe(v) }
+func (h *Histogram) name() string      { return h.metricName }
+
+func (h *Histogram) write(w io.Writer) {
+	header(w, h.metricName, h.help, "histogram")
+	h.h.write(w, h.metricName, "")
+}
+
+// HistogramVec is a histogram per value of one label
+type HistogramVec struct {
+	metricName, help, label string
+	buckets                 []float64
+	mutex                   sync.RWMutex
+	histograms              map[string]*histogram
+}
+
+// NewHistogramVec registers a histogram with one label
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	h := &HistogramVec{metricName: name, help: help, label: label, buckets: buckets, histograms: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram of a label value
+func (h *HistogramVec) Observe(value string, v float64) {
+	h.mutex.RLock()
+	hist, exists := h.histograms[value]
+	h.mutex.RUnlock()
+	if !exists {
+		h.mutex.Lock()
+		if hist, exists = h.histograms[value]; !exists {
+			hist = newHistogram(h.buckets)
+			h.histograms[value] = hist
+		}
+		h.mutex.Unlock()
+	}
+	hist.observe(v)
+}
+
+func (h *HistogramVec) name() string { return h.metricName }
+
+func (h *HistogramVec) write(w io.Writer) {
+	header(w, h.metricName, h.help, "histogram")
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	for _, value := range sortedKeys(h.histograms) {
+		h.histograms[value].write(w, h.metricName, fmt.Sprintf("%s=\"%s\",", h.label, escapeLabel(value)))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
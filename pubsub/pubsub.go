@@ -0,0 +1,218 @@
+package pubsub
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"tempDB/utils"
+)
+
+// Subscriber is the receiving end of a subscribed connection. Messages are
+// queued in a bounded buffer; a subscriber that lets it fill up is dropped
+// rather than slowing down the publishers.
+type Subscriber struct {
+	out      chan []byte
+	dropped  chan struct{}
+	dropOnce sync.Once
+
+	//guarded by the broker mutex
+	channels map[string]bool
+	patterns map[string]bool
+}
+
+// NewSubscriber creates a subscriber buffering up to limit pending messages
+func NewSubscriber(limit int) *Subscriber {
+	return &Subscriber{
+		out:      make(chan []byte, limit),
+		dropped:  make(chan struct{}),
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+	}
+}
+
+// Messages returns the RESP encoded messages waiting to be sent
+func (s *Subscriber) Messages() <-chan []byte {
+	return s.out
+}
+
+// Dropped is closed once the subscriber fell too far behind
+func (s *Subscriber) Dropped() <-chan struct{} {
+	return s.dropped
+}
+
+// deliver queues a message without ever blocking the publisher
+func (s *Subscriber) deliver(frame []byte) {
+	select {
+	case s.out <- frame:
+	default:
+		s.dropOnce.Do(func() { close(s.dropped) })
+	}
+}
+
+// Broker routes published messages to the subscribers of a channel or of a
+// glob pattern matching it.
+type Broker struct {
+	mutex    *sync.RWMutex
+	channels map[string]map[*Subscriber]bool
+	patterns map[string]map[*Subscriber]bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		mutex:    &sync.RWMutex{},
+		channels: make(map[string]map[*Subscriber]bool),
+		patterns: make(map[string]map[*Subscriber]bool),
+	}
+}
+
+// Subscribe adds s to channel and returns how many subscriptions s now has
+func (b *Broker) Subscribe(s *Subscriber, channel string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	add(b.channels, channel, s)
+	s.channels[channel] = true
+	return len(s.channels) + len(s.patterns)
+}
+
+// Unsubscribe removes s from channel and returns how many subscriptions s has left
+func (b *Broker) Unsubscribe(s *Subscriber, channel string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	remove(b.channels, channel, s)
+	delete(s.channels, channel)
+	return len(s.channels) + len(s.patterns)
+}
+
+// PSubscribe adds s to pattern and returns how many subscriptions s now has
+func (b *Broker) PSubscribe(s *Subscriber, pattern string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	add(b.patterns, pattern, s)
+	s.patterns[pattern] = true
+	return len(s.channels) + len(s.patterns)
+}
+
+// PUnsubscribe removes s from pattern and returns how many subscriptions s has left
+func (b *Broker) PUnsubscribe(s *Subscriber, pattern string) int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	remove(b.patterns, pattern, s)
+	delete(s.patterns, pattern)
+	return len(s.channels) + len(s.patterns)
+}
+
+// Subscriptions returns the channels and the patterns s is subscribed to
+func (b *Broker) Subscriptions(s *Subscriber) ([]string, []string) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return sortedKeys(s.channels), sortedKeys(s.patterns)
+}
+
+// Count returns how many channels and patterns s is subscribed to
+func (b *Broker) Count(s *Subscriber) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return len(s.channels) + len(s.patterns)
+}
+
+// UnsubscribeAll removes every subscription of s, when its connection goes away
+func (b *Broker) UnsubscribeAll(s *Subscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for channel := range s.channels {
+		remove(b.channels, channel, s)
+	}
+	for pattern := range s.patterns {
+		remove(b.patterns, pattern, s)
+	}
+	s.channels = make(map[string]bool)
+	s.patterns = make(map[string]bool)
+}
+
+// Publish sends payload to the subscribers of channel and of every pattern
+// matching it, returning how many received it
+func (b *Broker) Publish(channel string, payload []byte) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	receivers := 0
+	if subscribers, exists := b.channels[channel]; exists {
+		frame := []byte(fmt.Sprintf("*3\r\n+message\r\n+%s\r\n+%s\r\n", channel, payload))
+		for s := range subscribers {
+			s.deliver(frame)
+			receivers++
+		}
+	}
+	for pattern, subscribers := range b.patterns {
+		if !utils.GlobMatch(pattern, channel) {
+			continue
+		}
+		frame := []byte(fmt.Sprintf("*4\r\n+pmessage\r\n+%s\r\n+%s\r\n+%s\r\n", pattern, channel, payload))
+		for s := range subscribers {
+			s.deliver(frame)
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// ActiveChannels returns the channels with subscribers, optionally only those matching pattern
+func (b *Broker) ActiveChannels(pattern string) []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	channels := []string{}
+	for channel := range b.channels {
+		if pattern == "" || utils.GlobMatch(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
+// NumSub returns how many subscribers channel has, not counting patterns
+func (b *Broker) NumSub(channel string) int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return len(b.channels[channel])
+}
+
+// NumPat returns how many patterns are subscribed to
+func (b *Broker) NumPat() int {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return len(b.patterns)
+}
+
+func add(subscriptions map[string]map[*Subscriber]bool, name string, s *Subscriber) {
+	if subscriptions[name] == nil {
+		subscriptions[name] = make(map[*Subscriber]bool)
+	}
+	subscriptions[name][s] = true
+}
+
+func remove(subscriptions map[string]map[*Subscriber]bool, name string, s *Subscriber) {
+	delete(subscriptions[name], s)
+	if len(subscriptions[name]) == 0 {
+		delete(subscriptions, name)
+	}
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
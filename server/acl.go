@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"tempDB/config"
+	"tempDB/engine"
+	"time"
+)
+
+// handleACL handles the ACL command. It reports whether the command was
+// handled here.
+func (server *Server) handleACL(c *client, cmd []string) ([]byte, bool) {
+	if cmd[0] != "ACL" {
+		return nil, false
+	}
+	//it would run at once instead of being queued, so the transaction fails
+	if c.multi {
+		c.aborted = true
+		return []byte("-ERR ACL inside MULTI is not allowed\r\n"), true
+	}
+
+	args := cmd[2:]
+	wrongArgs := []byte(fmt.Sprintf("-ERR wrong number of arguments for 'acl|%s' command\r\n", strings.ToLower(cmd[1])))
+	switch strings.ToUpper(cmd[1]) {
+	case "SETUSER":
+		//SETUSER username [rule ...]
+		if len(args) < 1 {
+			return wrongArgs, true
+		}
+		if err := server.acl.SetUser(args[0], args[1:]); err != nil {
+			return []byte(fmt.Sprintf("-ERR %s\r\n", err)), true
+		}
+		return []byte("+OK\r\n"), true
+
+	case "GETUSER":
+		//GETUSER username
+		if len(args) != 1 {
+			return wrongArgs, true
+		}
+		user, exists := server.acl.User(args[0])
+		if !exists {
+			return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), true
+		}
+
+		flags := []string{"off"}
+		if user.Enabled {
+			flags[0] = "on"
+		}
+		if user.NoPass {
+			flags = append(flags, "nopass")
+		}
+		response := []byte("*10\r\n+flags\r\n" + stringsReply(flags) + "+passwords\r\n" + stringsReply(user.Passwords))
+		keys := []string{}
+		for _, pattern := range user.Keys {
+			keys = append(keys, "~"+pattern)
+		}
+		channels := []string{}
+		for _, pattern := range user.Channels {
+			channels = append(channels, "&"+pattern)
+		}
+		response = append(response, []byte(fmt.Sprintf("+commands\r\n+%s\r\n+keys\r\n+%s\r\n+channels\r\n+%s\r\n",
+			strings.Join(user.Commands, " "), strings.Join(keys, " "), strings.Join(channels, " ")))...)
+		return response, true
+
+	case "DELUSER":
+		//DELUSER username [username ...]
+		if len(args) < 1 {
+			return wrongArgs, true
+		}
+		deleted, err := server.acl.DelUser(args)
+		if err != nil {
+			return []byte(fmt.Sprintf("-ERR %s\r\n", err)), true
+		}
+		return []byte(fmt.Sprintf(":%d\r\n", deleted)), true
+
+	case "LIST":
+		rules := []string{}
+		for _, user := range server.acl.Users() {
+			rules = append(rules, fmt.Sprintf("user %s %s", user.Name, user.Rules()))
+		}
+		return []byte(stringsReply(rules)), true
+
+	case "USERS":
+		names := []string{}
+		for _, user := range server.acl.Users() {
+			names = append(names, user.Name)
+		}
+		return []byte(stringsReply(names)), true
+
+	case "WHOAMI":
+		return []byte(fmt.Sprintf("+%s\r\n", c.user)), true
+
+	case "CAT":
+		//CAT [category]
+		if len(args) == 0 {
+			return []byte(stringsReply(engine.Categories())), true
+		}
+		names := []string{}
+		for _, name := range engine.CommandsIn(strings.ToLower(args[0])) {
+			names = append(names, strings.ToLower(name))
+		}
+		if len(names) == 0 {
+			return []byte(fmt.Sprintf("-ERR Unknown category '%s'\r\n", args[0])), true
+		}
+		return []byte(stringsReply(names)), true
+
+	case "LOG":
+		//LOG [count | RESET]
+		count := 10
+		if len(args) == 1 {
+			if strings.ToUpper(args[0]) == "RESET" {
+				server.acl.ResetLog()
+				return []byte("+OK\r\n"), true
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return []byte("-ERR value is out of range, must be positive\r\n"), true
+			}
+			count = n
+		}
+
+		entries := server.acl.Log(count)
+		response := []byte(fmt.Sprintf("*%d\r\n", len(entries)))
+		for _, entry := range entries {
+			response = append(response, []byte(fmt.Sprintf("*14\r\n+count\r\n:%d\r\n+reason\r\n+%s\r\n+context\r\n+%s\r\n+object\r\n+%s\r\n+username\r\n+%s\r\n+age-seconds\r\n+%.3f\r\n+client-info\r\n+addr=%s\r\n",
+				entry.Count, entry.Reason, entry.Context, entry.Object, entry.Username, time.Since(entry.Time).Seconds(), entry.Client))...)
+		}
+		return response, true
+
+	case "LOAD", "SAVE":
+		path := config.GetServerConfig().ACLFile
+		if path == "" {
+			return []byte("-ERR This instance is not configured to use an ACL file, set acl_file in the configuration\r\n"), true
+		}
+		var err error
+		if strings.ToUpper(cmd[1]) == "LOAD" {
+			err = server.acl.LoadFile(path)
+		} else {
+			err = server.acl.SaveFile(path)
+		}
+		if err != nil {
+			return []byte(fmt.Sprintf("-ERR %s\r\n", err)), true
+		}
+		return []byte("+OK\r\n"), true
+	}
+
+	return []byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try ACL SETUSER, GETUSER, DELUSER, LIST, USERS, WHOAMI, CAT, LOG, LOAD or SAVE.\r\n", cmd[1])), true
+}
+
+// stringsReply encodes a list of strings as an array
+func stringsReply(values []string) string {
+	var response strings.Builder
+	fmt.Fprintf(&response, "*%d\r\n", len(values))
+	for _, value := range values {
+		fmt.Fprintf(&response, "+%s\r\n", value)
+	}
+	return response.String()
+}
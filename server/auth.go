@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"tempDB/acl"
+	"tempDB/engine"
+)
+
+// handleAuth handles AUTH. It reports whether the command was handled here.
+func (server *Server) handleAuth(c *client, cmd []string) ([]byte, bool) {
+	if cmd[0] != "AUTH" {
+		return nil, false
+	}
+
+	if c.multi {
+		return []byte("-ERR AUTH inside MULTI is not allowed\r\n"), true
+	}
+	if len(cmd) > 3 {
+		return []byte("-ERR syntax error\r\n"), true
+	}
+
+	//AUTH password authenticates the default user
+	username, password := "default", cmd[1]
+	if len(cmd) == 3 {
+		username, password = cmd[1], cmd[2]
+	} else if user, _ := server.acl.User("default"); user.NoPass {
+		return []byte("-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n"), true
+	}
+
+	if _, ok := server.acl.Authenticate(username, password); !ok {
+		server.acl.LogDenied(acl.LogEntry{Reason: "auth", Context: c.context(), Object: "AUTH", Username: username, Client: c.addr()})
+		return []byte("-WRONGPASS invalid username-password pair or user is disabled.\r\n"), true
+	}
+	c.user, c.authenticated = username, true
+	return []byte("+OK\r\n"), true
+}
+
+// checkPermissions checks that the user of the client may run the command
+// on its keys and channels, logging and returning the error if not
+func (server *Server) checkPermissions(c *client, info *engine.Command, args []string, context string) error {
+	deny := func(reason, object string, err error) error {
+		server.acl.LogDenied(acl.LogEntry{Reason: reason, Context: context, Object: object, Username: c.user, Client: c.addr()})
+		return err
+	}
+
+	user, exists := server.acl.User(c.user)
+	if !exists || !user.CanRun(info.Name, info.Categories()) {
+		name := strings.ToLower(info.Name)
+		return deny("command", name, fmt.Errorf("-NOPERM User %s has no permissions to run the '%s' command\r\n", c.user, name))
+	}
+
+	for _, key := range info.KeysOf(args) {
+		if !user.CanAccessKey(key) {
+			return deny("key", key, fmt.Errorf("-NOPERM No permissions to access a key\r\n"))
+		}
+	}
+
+	channelDenied := fmt.Errorf("-NOPERM No permissions to access a channel\r\n")
+	switch info.Name {
+	case "PUBLISH":
+		if !user.CanAccessChannel(args[0]) {
+			return deny("channel", args[0], channelDenied)
+		}
+	case "SUBSCRIBE":
+		for _, channel := range args {
+			if !user.CanAccessChannel(channel) {
+				return deny("channel", channel, channelDenied)
+			}
+		}
+	case "PSUBSCRIBE":
+		for _, pattern := range args {
+			if !user.CanAccessPattern(pattern) {
+				return deny("channel", pattern, channelDenied)
+			}
+		}
+	}
+	return nil
+}
+
+// authorizer checks the commands the scripts of a client call
+func (server *Server) authorizer(c *client) engine.Authorizer {
+	return func(info *engine.Command, args []string) error {
+		return server.checkPermissions(c, info, args, "script")
+	}
+}
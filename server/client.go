@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"tempDB/config"
+	"tempDB/pubsub"
+	"tempDB/utils"
+	"time"
+)
+
+// client holds the state of a single connection
+type client struct {
+	id         uint64
+	connection net.Conn
+	createdAt  time.Time
+	closed     chan struct{} // closed once the connection is torn down
+
+	closeAfterReply bool // CLIENT KILL killed the client itself
+
+	//what CLIENT LIST shows, read from the goroutines of other clients
+	infoMutex *sync.Mutex
+	info      clientInfo
+
+	//output buffer, replies are queued and sent by writeLoop so a client that
+	//stops reading holds up nothing but itself
+	writeMutex  *sync.Mutex // guards the output, pushed pub/sub messages are queued from another goroutine
+	sendMutex   *sync.Mutex // keeps the output in order while it is being sent
+	output      []byte
+	outputReady chan struct{}
+	overLimit   bool      // the output buffer limit was hit, nothing more is queued
+	softSince   time.Time // when the output went over the soft limit
+
+	authenticated bool   // AUTH succeeded, or the default user needs no password
+	user          string // ACL user the commands run as
+
+	//transaction state
+	multi   bool              // inside MULTI, commands are queued
+	queued  []utils.Request   // commands waiting for EXEC
+	aborted bool              // a command was rejected while queueing
+	watched map[string]uint64 // WATCHed keys and their versions at the time
+
+	//pub/sub state, created on the first subscription
+	subscriber *pubsub.Subscriber
+
+	log *slog.Logger
+}
+
+// clientInfo is the state of a client other clients may look at. The
+// connection goroutine publishes it after every command.
+type clientInfo struct {
+	name       string
+	user       string
+	lastActive time.Time
+	lastCmd    string
+	multi      int // commands queued inside MULTI, -1 outside
+	sub, psub  int // channels and patterns subscribed to
+	qbuf       int // bytes read but not parsed yet, e.g. pipelined commands
+}
+
+func newClient(connection net.Conn, log *slog.Logger) *client {
+	now := time.Now()
+	return &client{
+		connection:  connection,
+		createdAt:   now,
+		closed:      make(chan struct{}),
+		infoMutex:   &sync.Mutex{},
+		info:        clientInfo{user: "default", lastActive: now, multi: -1},
+		writeMutex:  &sync.Mutex{},
+		sendMutex:   &sync.Mutex{},
+		outputReady: make(chan struct{}, 1),
+		log:         log,
+	}
+}
+
+// publishInfo updates what other clients see of the client, after a command
+func (c *client) publishInfo(command string, sub int, psub int, qbuf int) {
+	multi := -1
+	if c.multi {
+		multi = len(c.queued)
+	}
+
+	c.infoMutex.Lock()
+	defer c.infoMutex.Unlock()
+	c.info.user = c.user
+	c.info.lastActive = time.Now()
+	c.info.lastCmd = command
+	c.info.multi = multi
+	c.info.sub, c.info.psub = sub, psub
+	c.info.qbuf = qbuf
+}
+
+// currentUser returns the user the client is authenticated as, safe to call
+// from other goroutines
+func (c *client) currentUser() string {
+	c.infoMutex.Lock()
+	defer c.infoMutex.Unlock()
+	return c.info.user
+}
+
+// addr returns the address of the client, as the ACL log shows it
+func (c *client) addr() string {
+	//unix socket clients have no address of their own
+	if c.connection.LocalAddr().Network() == "unix" {
+		return c.connection.LocalAddr().String() + ":0"
+	}
+	return c.connection.RemoteAddr().String()
+}
+
+// context returns where commands of the client run, as the ACL log shows it
+func (c *client) context() string {
+	if c.multi {
+		return "multi"
+	}
+	return "toplevel"
+}
+
+// write queues a reply, never interleaving with a pushed message
+func (c *client) write(response []byte) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.enqueue(response)
+}
+
+// enqueue adds a reply to the output buffer, disconnecting the client when
+// the buffer goes over its limit. The caller holds writeMutex.
+func (c *client) enqueue(response []byte) {
+	if c.overLimit {
+		return
+	}
+	c.output = append(c.output, response...)
+
+	//subscribers receive pushed messages, so they get a limit of their own
+	limits := config.GetServerConfig().ClientOutputBufferLimit
+	limit := limits.Normal
+	if c.subscriber != nil {
+		limit = limits.PubSub
+	}
+
+	size := len(c.output)
+	switch {
+	case limit.HardBytes > 0 && size >= limit.HardBytes:
+		c.overLimit = true
+	case limit.SoftBytes > 0 && size >= limit.SoftBytes:
+		if c.softSince.IsZero() {
+			c.softSince = time.Now()
+		} else if time.Since(c.softSince) >= time.Duration(limit.SoftSeconds)*time.Second {
+			c.overLimit = true
+		}
+	default:
+		c.softSince = time.Time{}
+	}
+	if c.overLimit {
+		c.log.Warn("Disconnecting client over its output buffer limit", "client", c.addr(), "bytes", size)
+		c.output = nil
+		c.connection.Close()
+		return
+	}
+
+	select {
+	case c.outputReady <- struct{}{}:
+	default:
+	}
+}
+
+// outputSize returns the number of bytes waiting to be sent
+func (c *client) outputSize() int {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return len(c.output)
+}
+
+// watchDisconnect closes the returned channel if the client goes away while
+// a blocking command waits, as nothing else reads the connection meanwhile.
+// Input sent by the client also ends the watch, and stays buffered for the
+// next command. The returned function stops watching, and must be called
+// before the next command is read.
+func (c *client) watchDisconnect(reader *bufio.Reader) (<-chan struct{}, func()) {
+	gone := make(chan struct{})
+	done := make(chan struct{})
+	c.connection.SetReadDeadline(time.Time{})
+
+	go func() {
+		defer close(done)
+		var netErr net.Error
+		if _, err := reader.Peek(1); err != nil && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			close(gone)
+		}
+	}()
+
+	return gone, func() {
+		//an expired deadline wakes the Peek
+		c.connection.SetReadDeadline(time.Now())
+		<-done
+	}
+}
+
+// writeLoop sends the queued output until the connection is torn down
+func (c *client) writeLoop() {
+	for {
+		select {
+		case <-c.outputReady:
+			if err := c.flush(); err != nil {
+				c.connection.Close()
+				return
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// flush sends the output queued so far
+func (c *client) flush() error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	c.writeMutex.Lock()
+	output := c.output
+	c.output = nil
+	c.writeMutex.Unlock()
+
+	if len(output) == 0 {
+		return nil
+	}
+	_, err := c.connection.Write(output)
+	return err
+}
+
+// resetTransaction drops the queued commands and the watched keys
+func (c *client) resetTransaction() {
+	c.multi = false
+	c.queued = nil
+	c.aborted = false
+	c.watched = nil
+}
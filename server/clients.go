@@ -0,0 +1,352 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"tempDB/acl"
+	"tempDB/engine"
+	"time"
+)
+
+// clientRegistry tracks the connected clients by ID
+type clientRegistry struct {
+	mutex   sync.RWMutex
+	clients map[uint64]*client
+	nextID  uint64
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{clients: make(map[uint64]*client)}
+}
+
+// add registers a client, giving it its ID, unless max clients are already
+// connected
+func (r *clientRegistry) add(c *client, max int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.clients) >= max {
+		return false
+	}
+	r.nextID++
+	c.id = r.nextID
+	r.clients[c.id] = c
+	return true
+}
+
+func (r *clientRegistry) remove(c *client) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.clients, c.id)
+}
+
+func (r *clientRegistry) count() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return len(r.clients)
+}
+
+// all returns the connected clients, in the order they connected
+func (r *clientRegistry) all() []*client {
+	r.mutex.RLock()
+	clients := make([]*client, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+	return clients
+}
+
+// clientPause holds back the commands of every client, or only the ones
+// that write, until it ends or CLIENT UNPAUSE lifts it
+type clientPause struct {
+	mutex      sync.Mutex
+	until      time.Time
+	writesOnly bool
+	lifted     chan struct{} // closed when the pause is lifted early
+}
+
+func newClientPause() *clientPause {
+	return &clientPause{lifted: make(chan struct{})}
+}
+
+func (p *clientPause) pause(until time.Time, writesOnly bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	//a longer pause is not shortened, and a pause of everything is not narrowed to writes
+	if p.until.After(time.Now()) {
+		if until.Before(p.until) {
+			until = p.until
+		}
+		writesOnly = writesOnly && p.writesOnly
+	}
+	p.until, p.writesOnly = until, writesOnly
+}
+
+func (p *clientPause) unpause() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.until = time.Time{}
+	close(p.lifted)
+	p.lifted = make(chan struct{})
+}
+
+// wait returns once a command, which writes or not, may run
+func (p *clientPause) wait(writes bool) {
+	for {
+		p.mutex.Lock()
+		remaining := time.Until(p.until)
+		held := remaining > 0 && (writes || !p.writesOnly)
+		lifted := p.lifted
+		p.mutex.Unlock()
+
+		if !held {
+			return
+		}
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-lifted:
+			timer.Stop()
+		}
+	}
+}
+
+// pausedWrites reports whether a command counts as a write for CLIENT PAUSE WRITE
+func pausedWrites(info *engine.Command) bool {
+	return info.Flags&engine.FlagWrite != 0 || info.Name == "EXEC" || info.Name == "PUBLISH"
+}
+
+// infoLine describes a client the way CLIENT LIST and CLIENT INFO show it
+func (server *Server) infoLine(c *client) string {
+	c.infoMutex.Lock()
+	info := c.info
+	c.infoMutex.Unlock()
+
+	flags := ""
+	if info.multi >= 0 {
+		flags += "x"
+	}
+	if info.sub+info.psub > 0 {
+		flags += "P"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	now := time.Now()
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=0 sub=%d psub=%d multi=%d qbuf=%d omem=%d cmd=%s user=%s",
+		c.id, c.addr(), c.connection.LocalAddr(), info.name, int(now.Sub(c.createdAt).Seconds()), int(now.Sub(info.lastActive).Seconds()),
+		flags, info.sub, info.psub, info.multi, info.qbuf, c.outputSize(), strings.ToLower(info.lastCmd), info.user)
+}
+
+// validClientName reports whether a name has no spaces or special characters, as CLIENT LIST separates fields by spaces
+func validClientName(name string) bool {
+	for _, r := range name {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// handleClient handles the CLIENT command. It reports whether the command
+// was handled here.
+func (server *Server) handleClient(c *client, cmd []string) ([]byte, bool) {
+	if cmd[0] != "CLIENT" {
+		return nil, false
+	}
+	//it would run at once instead of being queued, so the transaction fails
+	if c.multi {
+		c.aborted = true
+		return []byte("-ERR CLIENT inside MULTI is not allowed\r\n"), true
+	}
+
+	args := cmd[2:]
+	sub := strings.ToUpper(cmd[1])
+	wrongArgs := []byte(fmt.Sprintf("-ERR wrong number of arguments for 'client|%s' command\r\n", strings.ToLower(cmd[1])))
+
+	//managing other clients needs more than running CLIENT
+	switch sub {
+	case "LIST", "KILL", "PAUSE", "UNPAUSE":
+		if user, exists := server.acl.User(c.user); !exists || !user.CanRun("client", []string{"admin", "dangerous"}) {
+			server.acl.LogDenied(acl.LogEntry{Reason: "command", Context: c.context(), Object: "client|" + strings.ToLower(sub), Username: c.user, Client: c.addr()})
+			return []byte(fmt.Sprintf("-NOPERM User %s has no permissions to run the 'client|%s' command\r\n", c.user, strings.ToLower(sub))), true
+		}
+	}
+
+	switch sub {
+	case "ID":
+		return []byte(fmt.Sprintf(":%d\r\n", c.id)), true
+
+	case "INFO":
+		return []byte(fmt.Sprintf("+%s\r\n", server.infoLine(c))), true
+
+	case "LIST":
+		//LIST [ID id [id ...]]
+		clients := server.clients.all()
+		if len(args) > 0 {
+			if strings.ToUpper(args[0]) != "ID" || len(args) < 2 {
+				return []byte("-ERR syntax error\r\n"), true
+			}
+			wanted := make(map[uint64]bool)
+			for _, arg := range args[1:] {
+				id, err := strconv.ParseUint(arg, 10, 64)
+				if err != nil {
+					return []byte("-ERR Invalid client ID\r\n"), true
+				}
+				wanted[id] = true
+			}
+			kept := clients[:0]
+			for _, other := range clients {
+				if wanted[other.id] {
+					kept = append(kept, other)
+				}
+			}
+			clients = kept
+		}
+
+		lines := []string{}
+		for _, other := range clients {
+			lines = append(lines, server.infoLine(other))
+		}
+		return []byte(stringsReply(lines)), true
+
+	case "SETNAME":
+		//SETNAME name
+		if len(args) != 1 {
+			return wrongArgs, true
+		}
+		if !validClientName(args[0]) {
+			return []byte("-ERR Client names cannot contain spaces, newlines or special characters.\r\n"), true
+		}
+		c.infoMutex.Lock()
+		c.info.name = args[0]
+		c.infoMutex.Unlock()
+		return []byte("+OK\r\n"), true
+
+	case "GETNAME":
+		c.infoMutex.Lock()
+		name := c.info.name
+		c.infoMutex.Unlock()
+		if name == "" {
+			return []byte(fmt.Sprintf("+%s\r\n", "(nil)")), true
+		}
+		return []byte(fmt.Sprintf("+%s\r\n", name)), true
+
+	case "KILL":
+		return server.clientKill(c, args), true
+
+	case "PAUSE":
+		//PAUSE timeout-ms [WRITE | ALL]
+		if len(args) < 1 || len(args) > 2 {
+			return wrongArgs, true
+		}
+		ms, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || ms < 0 {
+			return []byte("-ERR timeout is not an integer or out of range\r\n"), true
+		}
+		writesOnly := false
+		if len(args) == 2 {
+			switch strings.ToUpper(args[1]) {
+			case "WRITE":
+				writesOnly = true
+			case "ALL":
+			default:
+				return []byte("-ERR syntax error\r\n"), true
+			}
+		}
+		server.pause.pause(time.Now().Add(time.Duration(ms)*time.Millisecond), writesOnly)
+		return []byte("+OK\r\n"), true
+
+	case "UNPAUSE":
+		server.pause.unpause()
+		return []byte("+OK\r\n"), true
+	}
+
+	return []byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try CLIENT ID, INFO, LIST, SETNAME, GETNAME, KILL, PAUSE or UNPAUSE.\r\n", cmd[1])), true
+}
+
+// kill disconnects a client, after the reply to CLIENT KILL when it killed itself
+func (server *Server) kill(c *client, other *client) {
+	if other == c {
+		c.closeAfterReply = true
+		return
+	}
+	other.connection.Close()
+}
+
+// clientKill handles CLIENT KILL addr, and CLIENT KILL with filters
+func (server *Server) clientKill(c *client, args []string) []byte {
+	if len(args) == 0 {
+		return []byte("-ERR wrong number of arguments for 'client|kill' command\r\n")
+	}
+
+	//the old form kills the client at an address, and is an error if there is none
+	if len(args) == 1 {
+		for _, other := range server.clients.all() {
+			if other.addr() == args[0] {
+				server.kill(c, other)
+				return []byte("+OK\r\n")
+			}
+		}
+		return []byte("-ERR No such client\r\n")
+	}
+
+	if len(args)%2 != 0 {
+		return []byte("-ERR syntax error\r\n")
+	}
+	var id uint64
+	var addr, laddr, user string
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1]
+		switch strings.ToUpper(args[i]) {
+		case "ID":
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil || n == 0 {
+				return []byte("-ERR client-id should be greater than 0\r\n")
+			}
+			id = n
+		case "ADDR":
+			addr = value
+		case "LADDR":
+			laddr = value
+		case "USER":
+			user = value
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return []byte("-ERR syntax error\r\n")
+			}
+		default:
+			return []byte("-ERR syntax error\r\n")
+		}
+	}
+
+	killed := 0
+	for _, other := range server.clients.all() {
+		switch {
+		case id != 0 && other.id != id:
+		case addr != "" && other.addr() != addr:
+		case laddr != "" && other.connection.LocalAddr().String() != laddr:
+		case user != "" && other.currentUser() != user:
+		case skipMe && other == c:
+		default:
+			server.kill(c, other)
+			killed++
+		}
+	}
+	return []byte(fmt.Sprintf(":%d\r\n", killed))
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"tempDB/config"
+	"tempDB/engine"
+	"time"
+)
+
+// Version is the version INFO reports, set at build time with
+// -ldflags "-X tempDB/server.Version=..."
+var Version = "dev"
+
+// serverStats counts what INFO reports of the connections and commands
+type serverStats struct {
+	startedAt           time.Time
+	connectionsReceived atomic.Int64
+	rejectedConnections atomic.Int64
+	commandsProcessed   atomic.Int64
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{startedAt: time.Now()}
+}
+
+// infoSections are every section of INFO, in order
+var infoSections = append([]string{"server", "clients"}, engine.InfoSections...)
+
+// section returns the fields of a section of INFO
+func (server *Server) section(name string) []string {
+	switch name {
+	case "server":
+		cfg := config.GetServerConfig()
+		listeners := []string{}
+		for _, listener := range cfg.Listeners {
+			listeners = append(listeners, listener.Network+":"+listener.Address)
+		}
+		uptime := time.Since(server.stats.startedAt)
+		return []string{
+			fmt.Sprintf("tempdb_version:%s", Version),
+			fmt.Sprintf("go_version:%s", runtime.Version()),
+			fmt.Sprintf("os:%s %s", runtime.GOOS, runtime.GOARCH),
+			fmt.Sprintf("process_id:%d", os.Getpid()),
+			fmt.Sprintf("listeners:%s", strings.Join(listeners, ",")),
+			fmt.Sprintf("uptime_in_seconds:%d", int64(uptime.Seconds())),
+			fmt.Sprintf("uptime_in_days:%d", int64(uptime.Hours()/24)),
+		}
+
+	case "clients":
+		pubsubClients := 0
+		for _, c := range server.clients.all() {
+			c.infoMutex.Lock()
+			if c.info.sub+c.info.psub > 0 {
+				pubsubClients++
+			}
+			c.infoMutex.Unlock()
+		}
+		return []string{
+			fmt.Sprintf("connected_clients:%d", server.clients.count()),
+			fmt.Sprintf("maxclients:%d", config.GetServerConfig().MaxClients),
+			fmt.Sprintf("pubsub_clients:%d", pubsubClients),
+		}
+
+	case "stats":
+		return append([]string{
+			fmt.Sprintf("total_connections_received:%d", server.stats.connectionsReceived.Load()),
+			fmt.Sprintf("rejected_connections:%d", server.stats.rejectedConnections.Load()),
+			fmt.Sprintf("total_commands_processed:%d", server.stats.commandsProcessed.Load()),
+		}, server.Db.Info(name)...)
+	}
+	return server.Db.Info(name)
+}
+
+// handleInfo handles the INFO command. It reports whether the command was
+// handled here.
+func (server *Server) handleInfo(c *client, cmd []string) ([]byte, bool) {
+	if cmd[0] != "INFO" {
+		return nil, false
+	}
+	//it would run at once instead of being queued, so the transaction fails
+	if c.multi {
+		c.aborted = true
+		return []byte("-ERR INFO inside MULTI is not allowed\r\n"), true
+	}
+
+	//INFO [section ...], every section when none or all, everything or default is given
+	wanted := make(map[string]bool)
+	for _, name := range cmd[1:] {
+		wanted[strings.ToLower(name)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["everything"] || wanted["default"]
+
+	var text strings.Builder
+	for _, name := range infoSections {
+		if !all && !wanted[name] {
+			continue
+		}
+		if text.Len() > 0 {
+			text.WriteString("\r\n")
+		}
+		fmt.Fprintf(&text, "# %s\r\n", strings.ToUpper(name[:1])+name[1:])
+		for _, field := range server.section(name) {
+			text.WriteString(field + "\r\n")
+		}
+	}
+
+	//the report spans several lines, which only a bulk string can carry
+	return []byte(fmt.Sprintf("$%d\r\n%s\r\n", text.Len(), text.String())), true
+}
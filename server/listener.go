@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"tempDB/config"
+	"time"
+)
+
+// listen opens the listener of an address, wrapped in TLS when it asks for it
+func listen(cfg config.ListenerConfig, tlsConfig *tls.Config) (net.Listener, error) {
+	switch cfg.Network {
+	case "tcp":
+		//a negative period disables keepalives
+		keepAlive := time.Duration(config.GetServerConfig().TCPKeepAliveSeconds) * time.Second
+		listenConfig := net.ListenConfig{KeepAlive: keepAlive}
+		listener, err := listenConfig.Listen(context.Background(), "tcp", cfg.Address)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.TLS {
+			if tlsConfig == nil {
+				listener.Close()
+				return nil, fmt.Errorf("listener %s asks for TLS but tls.cert_file is not set", cfg.Address)
+			}
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		return listener, nil
+
+	case "unix":
+		if cfg.TLS {
+			return nil, fmt.Errorf("listener %s: TLS is not supported on unix sockets", cfg.Address)
+		}
+		return listenUnix(cfg)
+	}
+
+	return nil, fmt.Errorf("unknown listener network %q, expected tcp or unix", cfg.Network)
+}
+
+// listenUnix opens a unix socket, replacing the socket file a previous run
+// left behind. The file is removed again when the listener is closed.
+func listenUnix(cfg config.ListenerConfig) (net.Listener, error) {
+	var mode fs.FileMode
+	if cfg.Permissions != "" {
+		perm, err := strconv.ParseUint(cfg.Permissions, 8, 32)
+		if err != nil || perm > 0777 {
+			return nil, fmt.Errorf("invalid permissions %q for %s", cfg.Permissions, cfg.Address)
+		}
+		mode = fs.FileMode(perm)
+	}
+
+	if info, err := os.Stat(cfg.Address); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", cfg.Address)
+		}
+		os.Remove(cfg.Address)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Permissions != "" {
+		if err := os.Chmod(cfg.Address, mode); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// serve accepts the connections of a listener until it is closed
+func (server *Server) serve(listener net.Listener) {
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			server.log.Warn("Failed to accept connection", "err", err)
+			continue
+		}
+
+		//Run seperate Goroutine to handle connections
+		go server.handleConnection(connection)
+	}
+}
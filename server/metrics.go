@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"tempDB/metrics"
+	"time"
+)
+
+// serveMetrics registers the metrics of the connections and serves every
+// metric at /metrics on address, in the Prometheus text format
+func (server *Server) serveMetrics(address string) error {
+	metrics.NewGaugeFunc("tempdb_connected_clients", "Clients currently connected.", func() float64 {
+		return float64(server.clients.count())
+	})
+	metrics.NewCounterFunc("tempdb_connections_received_total", "Connections accepted.", func() float64 {
+		return float64(server.stats.connectionsReceived.Load())
+	})
+	metrics.NewCounterFunc("tempdb_rejected_connections_total", "Connections closed because maxclients was reached.", func() float64 {
+		return float64(server.stats.rejectedConnections.Load())
+	})
+	metrics.NewCounterFunc("tempdb_commands_processed_total", "Commands received from clients, including the ones the server handles itself.", func() float64 {
+		return float64(server.stats.commandsProcessed.Load())
+	})
+	metrics.NewGaugeFunc("tempdb_uptime_seconds", "Seconds since the server started.", func() float64 {
+		return time.Since(server.stats.startedAt).Seconds()
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		metrics.WriteTo(w)
+	})
+
+	//listen here, so a bad address stops the server from starting
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	server.log.Info("Serving metrics", "url", fmt.Sprintf("http://%s/metrics", listener.Addr()))
+
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := httpServer.Serve(listener); err != nil {
+			server.log.Error("Metrics server stopped", "err", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"tempDB/config"
+	"tempDB/pubsub"
+)
+
+// handlePubSub handles SUBSCRIBE, PSUBSCRIBE, UNSUBSCRIBE and PUNSUBSCRIBE,
+// and keeps a subscribed client to the commands allowed in that mode. It
+// reports whether the command was handled here; the replies of the
+// subscription commands are queued while subscribing, so they always reach the
+// client before the first message of a new subscription.
+func (server *Server) handlePubSub(c *client, cmd []string) ([]byte, bool) {
+	switch cmd[0] {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		if c.multi {
+			return []byte(fmt.Sprintf("-ERR %s inside MULTI is not allowed\r\n", cmd[0])), true
+		}
+	default:
+		if server.subscribed(c) && cmd[0] != "PING" {
+			return []byte(fmt.Sprintf("-ERR Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context\r\n", strings.ToLower(cmd[0]))), true
+		}
+		return nil, false
+	}
+
+	broker := server.Db.Broker()
+	if c.subscriber == nil {
+		c.subscriber = pubsub.NewSubscriber(config.GetPubSubConfig().MaxPendingMessages)
+		go server.pushMessages(c)
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	names := cmd[1:]
+	switch cmd[0] {
+	case "SUBSCRIBE":
+		for _, channel := range names {
+			count := broker.Subscribe(c.subscriber, channel)
+			c.enqueue(subscriptionReply("subscribe", channel, count))
+		}
+	case "PSUBSCRIBE":
+		for _, pattern := range names {
+			count := broker.PSubscribe(c.subscriber, pattern)
+			c.enqueue(subscriptionReply("psubscribe", pattern, count))
+		}
+	case "UNSUBSCRIBE":
+		if len(names) == 0 {
+			names, _ = broker.Subscriptions(c.subscriber)
+		}
+		if len(names) == 0 {
+			c.enqueue(subscriptionReply("unsubscribe", "(nil)", broker.Count(c.subscriber)))
+		}
+		for _, channel := range names {
+			count := broker.Unsubscribe(c.subscriber, channel)
+			c.enqueue(subscriptionReply("unsubscribe", channel, count))
+		}
+	case "PUNSUBSCRIBE":
+		if len(names) == 0 {
+			_, names = broker.Subscriptions(c.subscriber)
+		}
+		if len(names) == 0 {
+			c.enqueue(subscriptionReply("punsubscribe", "(nil)", broker.Count(c.subscriber)))
+		}
+		for _, pattern := range names {
+			count := broker.PUnsubscribe(c.subscriber, pattern)
+			c.enqueue(subscriptionReply("punsubscribe", pattern, count))
+		}
+	}
+	return nil, true
+}
+
+// pushMessages writes the messages published to a subscribed client until
+// the connection goes away, and closes it when the client falls too far behind
+func (server *Server) pushMessages(c *client) {
+	//watched separately, as a write can be stuck on a client that stopped reading
+	go func() {
+		select {
+		case <-c.subscriber.Dropped():
+			server.log.Warn("Disconnecting slow subscriber", "client", c.addr())
+			c.connection.Close()
+		case <-c.closed:
+		}
+	}()
+
+	for {
+		select {
+		case frame := <-c.subscriber.Messages():
+			c.write(frame)
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// subscribed reports whether the client has any subscription left
+func (server *Server) subscribed(c *client) bool {
+	return c.subscriber != nil && server.Db.Broker().Count(c.subscriber) > 0
+}
+
+func subscriptionReply(kind string, name string, count int) []byte {
+	return []byte(fmt.Sprintf("*3\r\n+%s\r\n+%s\r\n:%d\r\n", kind, name, count))
+}
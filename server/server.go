@@ -2,22 +2,62 @@ package server
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net"
+	"strings"
+	"sync"
+	"tempDB/acl"
 	"tempDB/config"
 	"tempDB/engine"
+	"tempDB/logging"
 	"tempDB/utils"
+	"time"
 )
 
+// closeFlushTimeout bounds how long a closing connection may take to send its last replies
+const closeFlushTimeout = time.Second
+
 type Server struct {
-	Listener net.Listener
-	Db       engine.Store
+	Listeners []net.Listener
+	Db        engine.Store
+	acl       *acl.ACL
+	clients   *clientRegistry
+	pause     *clientPause
+	stats     *serverStats
+	slowlog   *slowlog
+	log       *slog.Logger
 }
 
 func Init() Server {
-	//cfg := config.GetServerConfig()
+	cfg := config.GetServerConfig()
+	log := logging.For("server")
+
+	//requirepass is the password of the default user, which an ACL file may redefine
+	users := acl.New()
+	if cfg.RequirePass != "" {
+		if err := users.SetUser("default", []string{"resetpass", "#" + cfg.RequirePass}); err != nil {
+			log.Error("Invalid requirepass", "err", err)
+			panic(err)
+		}
+	}
+	if cfg.ACLFile != "" {
+		if err := users.LoadFile(cfg.ACLFile); err != nil {
+			log.Error("Failed to load ACL file", "file", cfg.ACLFile, "err", err)
+			panic(err)
+		}
+	}
+
 	return Server{
-		Db: engine.NewStore(),
+		Db:      engine.NewStore(),
+		acl:     users,
+		clients: newClientRegistry(),
+		pause:   newClientPause(),
+		stats:   newServerStats(),
+		slowlog: newSlowlog(cfg.SlowlogMaxLen),
+		log:     log,
 	}
 }
 
@@ -25,61 +65,195 @@ func (server *Server) Start() {
 
 	//Read the configs
 	cfg := config.GetServerConfig()
-	addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
-	fmt.Printf("Starting server on: %s\n", addr)
 
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		fmt.Println("Error While Starting server: ", err)
-		panic(err)
+	//TLS listeners share the certificates, every connection must complete a handshake
+	var tlsConfig *tls.Config
+	if cfg.TLS.CertFile != "" {
+		var err error
+		tlsConfig, err = newTLSConfig(&cfg.TLS, server.log)
+		if err != nil {
+			server.log.Error("Failed to load TLS certificates", "err", err)
+			panic(err)
+		}
+		server.log.Info("TLS enabled", "client_auth", cfg.TLS.ClientAuth)
 	}
 
-	defer listener.Close()
-	server.Listener = listener
-
-	fmt.Println("Listening for connections ...")
-	for {
-		connection, err := server.Listener.Accept()
+	for _, listenerCfg := range cfg.Listeners {
+		server.log.Info("Starting listener", "network", listenerCfg.Network, "address", listenerCfg.Address, "tls", listenerCfg.TLS)
+		listener, err := listen(listenerCfg, tlsConfig)
 		if err != nil {
-			fmt.Printf("Connection Refused: %s\n", err)
-			continue
+			server.log.Error("Failed to start listener", "network", listenerCfg.Network, "address", listenerCfg.Address, "err", err)
+			panic(err)
 		}
+		server.Listeners = append(server.Listeners, listener)
+	}
 
-		fmt.Println(connection)
-		// tcpConn, ok := connection.(*net.TCPConn)
-		// if ok {
-		// 	fmt.Println("TCP info:")
-		// 	fmt.Println("   Local address:", tcpConn.LocalAddr())
-		// 	fmt.Println("   Remote address:", tcpConn.RemoteAddr())
-		// 	fmt.Println("   Set keepalive:", tcpConn.SetKeepAlive(true))
-		// 	fmt.Println("   Set keepalive period:", tcpConn.SetKeepAlivePeriod(30))
-		// } else {
-		// 	fmt.Println("Not a TCP connection !")
-		// }
-
-		//Run seperate Goroutine to handle connections
-		go server.handleConnection(connection)
+	//metrics are served apart from the clients, over HTTP
+	if cfg.MetricsAddress != "" {
+		if err := server.serveMetrics(cfg.MetricsAddress); err != nil {
+			server.log.Error("Failed to start metrics server", "address", cfg.MetricsAddress, "err", err)
+			panic(err)
+		}
+	}
+
+	//every listener is served by the same connection handler
+	var wg sync.WaitGroup
+	server.log.Info("Listening for connections")
+	for _, listener := range server.Listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			defer listener.Close()
+			server.serve(listener)
+		}(listener)
 	}
+	wg.Wait()
 }
 
 func (server *Server) handleConnection(connection net.Conn) {
 
-	//defer connection.Close()
+	cfg := config.GetServerConfig()
 	reader := bufio.NewReader(connection)
+	client := newClient(connection, server.log)
+	server.log.Debug("Accepted connection", "client", client.addr(), "local", connection.LocalAddr().String())
+	client.user = "default"
+	if user, exists := server.acl.User("default"); exists && user.Enabled && user.NoPass {
+		client.authenticated = true
+	}
+	go client.writeLoop()
+	defer server.closeClient(client)
+
+	server.stats.connectionsReceived.Add(1)
+	if !server.clients.add(client, cfg.MaxClients) {
+		server.stats.rejectedConnections.Add(1)
+		client.write([]byte("-ERR max number of clients reached\r\n"))
+		return
+	}
+	defer server.clients.remove(client)
 
+	if tlsConn, ok := connection.(*tls.Conn); ok {
+		if err := server.authenticateCertificate(client, tlsConn); err != nil {
+			server.log.Warn("TLS handshake failed", "client", client.addr(), "err", err)
+			return
+		}
+	}
+
+	idleTimeout := time.Duration(cfg.IdleTimeoutSeconds) * time.Second
+	lastCmd := ""
 	for {
 
+		//what CLIENT LIST shows, once the previous command is done, and
+		//again when the next one arrives
+		server.publishInfo(client, lastCmd, reader.Buffered())
+		if client.closeAfterReply {
+			return
+		}
+
+		//subscribers wait for messages, they are never idle
+		if idleTimeout > 0 && !server.subscribed(client) {
+			connection.SetReadDeadline(time.Now().Add(idleTimeout))
+		} else {
+			connection.SetReadDeadline(time.Time{})
+		}
+
 		//parse the incoming Bytes
 		cmd, err := utils.ParseRESP(reader)
 		if err != nil {
-			connection.Write([]byte(err.Error()))
+			var netErr net.Error
+			switch {
+			case errors.Is(err, utils.ErrProtocol):
+				//the rest of the stream cannot be parsed
+				client.write([]byte(fmt.Sprintf("-ERR %s\r\n", err)))
+			case errors.As(err, &netErr) && netErr.Timeout():
+				server.log.Info("Closing idle client", "client", client.addr())
+			}
+			//anything else means the client went away, or the connection was
+			//closed on our side, e.g. a dropped subscriber
+			return
+		}
+		if len(cmd) == 0 {
+			continue
+		}
+		lastCmd = cmd[0]
+		server.publishInfo(client, lastCmd, reader.Buffered())
+		if strings.EqualFold(cmd[0], "AUTH") || strings.EqualFold(cmd[0], "ACL") {
+			server.log.Debug("Parsed", "client", client.addr(), "cmd", cmd[0]) //never log passwords, even with log_values
+		} else {
+			server.log.Debug("Parsed", "client", client.addr(), "cmd", cmd[0], "args", logging.Values(cmd[1:]))
+		}
+
+		//nothing but AUTH is answered before authenticating, not even errors
+		if !client.authenticated && !strings.EqualFold(cmd[0], "AUTH") {
+			client.write([]byte("-NOAUTH Authentication required.\r\n"))
 			continue
 		}
-		fmt.Println("Parsed: ", cmd)
 
-		//check the validity of the commands
-		if len(cmd) == 0 || !utils.ValidCommand(cmd) {
-			connection.Write([]byte("+Invalid command"))
+		//check the validity of the commands, normalizing the name
+		if err := engine.CheckCommand(cmd); err != nil {
+			//a bad command inside MULTI fails the whole transaction
+			if client.multi {
+				client.aborted = true
+			}
+			client.write(engine.ErrorReply(err))
+			continue
+		}
+		server.stats.commandsProcessed.Add(1)
+		started := time.Now()
+
+		//authentication
+		if response, handled := server.handleAuth(client, cmd); handled {
+			server.reply(client, cmd, time.Since(started), response)
+			continue
+		}
+
+		//permissions of the ACL user, checked when queued inside MULTI
+		info, _ := engine.LookupCommand(cmd[0])
+		if err := server.checkPermissions(client, info, cmd[1:], client.context()); err != nil {
+			if client.multi {
+				client.aborted = true
+			}
+			client.write(engine.ErrorReply(err))
+			continue
+		}
+
+		//user management
+		if response, handled := server.handleACL(client, cmd); handled {
+			server.reply(client, cmd, time.Since(started), response)
+			continue
+		}
+
+		//connection management, never paused so CLIENT UNPAUSE gets through
+		if response, handled := server.handleClient(client, cmd); handled {
+			server.reply(client, cmd, time.Since(started), response)
+			continue
+		}
+
+		//server report, and the slow commands
+		if response, handled := server.handleInfo(client, cmd); handled {
+			server.reply(client, cmd, time.Since(started), response)
+			continue
+		}
+		if response, handled := server.handleSlowlog(client, cmd); handled {
+			server.reply(client, cmd, time.Since(started), response)
+			continue
+		}
+
+		//commands wait out CLIENT PAUSE, queued ones when EXEC runs them.
+		//The wait does not count towards the slow log.
+		if !client.multi || cmd[0] == "EXEC" {
+			server.pause.wait(pausedWrites(info))
+			started = time.Now()
+		}
+
+		//subscriptions, and the limits of a subscribed connection
+		if response, handled := server.handlePubSub(client, cmd); handled {
+			server.reply(client, cmd, time.Since(started), response)
+			continue
+		}
+
+		//transaction commands, and anything queued inside MULTI
+		if response, handled := server.handleTransaction(client, cmd); handled {
+			server.reply(client, cmd, time.Since(started), response)
 			continue
 		}
 
@@ -88,18 +262,41 @@ func (server *Server) handleConnection(connection net.Conn) {
 			Command: cmd[0],
 			Params:  cmd[1:],
 		}
-		fmt.Println("Computing ...: ", command)
-		response, dbError := server.Db.CommandHandler(command)
-
+		var gone <-chan struct{}
+		stopWatching := func() {}
+		if info.Flags&engine.FlagBlocking != 0 {
+			gone, stopWatching = client.watchDisconnect(reader)
+		}
+		response, elapsed, dbError := server.Db.CommandHandler(command, server.authorizer(client), gone)
+		stopWatching()
 		if dbError != nil {
-			fmt.Println("ERR: ", dbError)
-			connection.Write([]byte("+Failed"))
-		} else {
-			fmt.Println("RES: ", string(response))
-			connection.Write(response)
-			fmt.Println("Sent: ", string(response))
+			response = engine.ErrorReply(dbError)
 		}
+		server.reply(client, cmd, elapsed, response)
+		server.log.Debug("Replied", "client", client.addr(), "cmd", command.Command, "reply", logging.Reply(response))
 
 	} //for
 
 }
+
+// publishInfo updates what other clients see of a client
+func (server *Server) publishInfo(c *client, command string, qbuf int) {
+	sub, psub := 0, 0
+	if c.subscriber != nil {
+		channels, patterns := server.Db.Broker().Subscriptions(c.subscriber)
+		sub, psub = len(channels), len(patterns)
+	}
+	c.publishInfo(command, sub, psub, qbuf)
+}
+
+// closeClient tears down the state of a connection that is going away,
+// giving the output still queued a moment to reach it
+func (server *Server) closeClient(c *client) {
+	close(c.closed)
+	if c.subscriber != nil {
+		server.Db.Broker().UnsubscribeAll(c.subscriber)
+	}
+	c.connection.SetWriteDeadline(time.Now().Add(closeFlushTimeout))
+	c.flush()
+	c.connection.Close()
+}
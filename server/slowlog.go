@@ -0,0 +1,174 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"tempDB/config"
+	"time"
+)
+
+const (
+	slowlogMaxArgs   = 32  // arguments kept of a command, the rest are counted
+	slowlogMaxArgLen = 128 // bytes kept of an argument, the rest are counted
+)
+
+// slowlogEntry is a command that ran for longer than the threshold
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     []string // the command and its arguments, truncated
+	client   string
+	name     string
+}
+
+// slowlog keeps the latest slow commands in a ring buffer
+type slowlog struct {
+	mutex   sync.Mutex
+	entries []slowlogEntry // ring buffer of at most maxLen entries
+	maxLen  int
+	next    int // where the next entry goes once the buffer is full
+	nextID  int64
+}
+
+func newSlowlog(maxLen int) *slowlog {
+	return &slowlog{maxLen: maxLen}
+}
+
+// slowlogArgs truncates the arguments of a command, as the slow log keeps
+// them. The arguments of ACL may hold passwords and are left out.
+func slowlogArgs(cmd []string) []string {
+	if cmd[0] == "ACL" && len(cmd) > 2 {
+		return []string{cmd[0], cmd[1], fmt.Sprintf("... (%d more arguments)", len(cmd)-2)}
+	}
+
+	args := []string{}
+	for i, arg := range cmd {
+		if i == slowlogMaxArgs-1 && len(cmd) > slowlogMaxArgs {
+			args = append(args, fmt.Sprintf("... (%d more arguments)", len(cmd)-i))
+			break
+		}
+		if len(arg) > slowlogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen)
+		}
+		args = append(args, arg)
+	}
+	return args
+}
+
+// record adds a command to the slow log if it ran for longer than
+// slowlog_log_slower_than. AUTH is never recorded.
+func (sl *slowlog) record(c *client, cmd []string, elapsed time.Duration) {
+	threshold := time.Duration(*config.GetServerConfig().SlowlogLogSlowerThan) * time.Microsecond
+	if threshold < 0 || elapsed < threshold || cmd[0] == "AUTH" || sl.maxLen <= 0 {
+		return
+	}
+
+	c.infoMutex.Lock()
+	name := c.info.name
+	c.infoMutex.Unlock()
+	entry := slowlogEntry{time: time.Now(), duration: elapsed, args: slowlogArgs(cmd), client: c.addr(), name: name}
+
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	entry.id = sl.nextID
+	sl.nextID++
+	if len(sl.entries) < sl.maxLen {
+		sl.entries = append(sl.entries, entry)
+		return
+	}
+	sl.entries[sl.next] = entry
+	sl.next = (sl.next + 1) % sl.maxLen
+}
+
+// latest returns up to count entries, newest first, every entry when count is negative
+func (sl *slowlog) latest(count int) []slowlogEntry {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+
+	if count < 0 || count > len(sl.entries) {
+		count = len(sl.entries)
+	}
+	entries := make([]slowlogEntry, 0, count)
+	for i := 0; i < count; i++ {
+		//the newest entry is just before next, which stays 0 until the buffer is full
+		entries = append(entries, sl.entries[(sl.next-1-i+2*len(sl.entries))%len(sl.entries)])
+	}
+	return entries
+}
+
+func (sl *slowlog) len() int {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	return len(sl.entries)
+}
+
+func (sl *slowlog) reset() {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	sl.entries = nil
+	sl.next = 0
+}
+
+// reply queues the reply to a command, recording the command in the slow log
+// when it ran for too long
+func (server *Server) reply(c *client, cmd []string, elapsed time.Duration, response []byte) {
+	if response != nil {
+		c.write(response)
+	}
+	server.slowlog.record(c, cmd, elapsed)
+}
+
+// handleSlowlog handles the SLOWLOG command. It reports whether the command
+// was handled here.
+func (server *Server) handleSlowlog(c *client, cmd []string) ([]byte, bool) {
+	if cmd[0] != "SLOWLOG" {
+		return nil, false
+	}
+	//it would run at once instead of being queued, so the transaction fails
+	if c.multi {
+		c.aborted = true
+		return []byte("-ERR SLOWLOG inside MULTI is not allowed\r\n"), true
+	}
+
+	args := cmd[2:]
+	switch strings.ToUpper(cmd[1]) {
+	case "GET":
+		//GET [count], 10 by default, -1 for every entry
+		count := 10
+		if len(args) > 1 {
+			return []byte("-ERR wrong number of arguments for 'slowlog|get' command\r\n"), true
+		}
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < -1 {
+				return []byte("-ERR count should be greater than or equal to -1\r\n"), true
+			}
+			count = n
+		}
+
+		entries := server.slowlog.latest(count)
+		response := []byte(fmt.Sprintf("*%d\r\n", len(entries)))
+		for _, entry := range entries {
+			response = append(response, []byte(fmt.Sprintf("*6\r\n:%d\r\n:%d\r\n:%d\r\n*%d\r\n", entry.id, entry.time.Unix(), entry.duration.Microseconds(), len(entry.args)))...)
+			//arguments may hold any bytes, which only a bulk string can carry
+			for _, arg := range entry.args {
+				response = append(response, []byte(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))...)
+			}
+			response = append(response, []byte(fmt.Sprintf("+%s\r\n+%s\r\n", entry.client, entry.name))...)
+		}
+		return response, true
+
+	case "LEN":
+		return []byte(fmt.Sprintf(":%d\r\n", server.slowlog.len())), true
+
+	case "RESET":
+		server.slowlog.reset()
+		return []byte("+OK\r\n"), true
+	}
+
+	return []byte(fmt.Sprintf("-ERR unknown subcommand '%s'. Try SLOWLOG GET, LEN or RESET.\r\n", cmd[1])), true
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+	"tempDB/config"
+	"time"
+)
+
+// handshakeTimeout bounds how long a client may take to complete the TLS handshake
+const handshakeTimeout = 10 * time.Second
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"required": tls.RequireAndVerifyClientCert,
+}
+
+// tlsCertificates serves the certificate and client CAs currently on disk.
+// Every handshake takes the latest loaded configuration, so replacing the
+// files is enough to rotate a certificate without a restart.
+type tlsCertificates struct {
+	cfg      *config.TLSConfig
+	current  atomic.Pointer[tls.Config]
+	loadedAt time.Time // newest modification time of the files when loaded
+	log      *slog.Logger
+}
+
+// newTLSConfig builds the TLS configuration of the listener from the config,
+// loading the certificate files once to fail early when they are invalid
+func newTLSConfig(cfg *config.TLSConfig, log *slog.Logger) (*tls.Config, error) {
+	certs := &tlsCertificates{cfg: cfg, log: log}
+	if err := certs.load(); err != nil {
+		return nil, err
+	}
+	//a negative interval disables reloading
+	if cfg.ReloadIntervalSeconds > 0 {
+		go certs.reloadLoop(time.Duration(cfg.ReloadIntervalSeconds) * time.Second)
+	}
+
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return certs.current.Load(), nil
+		},
+	}, nil
+}
+
+// load reads the certificate files and replaces the configuration served
+func (certs *tlsCertificates) load() error {
+	cfg := certs.cfg
+	modTime := certs.modTime()
+
+	minVersion, exists := tlsVersions[cfg.MinVersion]
+	if !exists {
+		return fmt.Errorf("unknown TLS min_version %q, expected 1.0, 1.1, 1.2 or 1.3", cfg.MinVersion)
+	}
+	clientAuth, exists := clientAuthTypes[strings.ToLower(cfg.ClientAuth)]
+	if !exists {
+		return fmt.Errorf("unknown TLS client_auth %q, expected none, optional or required", cfg.ClientAuth)
+	}
+	ciphers, err := parseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", cfg.CAFile)
+		}
+	} else if clientAuth != tls.NoClientCert {
+		return errors.New("TLS client_auth requires a ca_file to verify client certificates against")
+	}
+
+	certs.current.Store(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   clientAuth,
+		MinVersion:   minVersion,
+		CipherSuites: ciphers,
+	})
+	certs.loadedAt = modTime
+	return nil
+}
+
+// modTime returns the newest modification time of the certificate files
+func (certs *tlsCertificates) modTime() time.Time {
+	var newest time.Time
+	for _, path := range []string{certs.cfg.CertFile, certs.cfg.KeyFile, certs.cfg.CAFile} {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest
+}
+
+// reloadLoop reloads the certificates whenever their files change, keeping
+// the previous ones if the new files cannot be loaded
+func (certs *tlsCertificates) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		modTime := certs.modTime()
+		if !modTime.After(certs.loadedAt) {
+			continue
+		}
+		if err := certs.load(); err != nil {
+			//retried once the files change again
+			certs.loadedAt = modTime
+			certs.log.Error("Failed to reload TLS certificates, keeping the current ones", "err", err)
+			continue
+		}
+		certs.log.Info("Reloaded TLS certificates")
+	}
+}
+
+// parseCipherSuites maps cipher suite names to their IDs. Only TLS 1.2 and
+// below are affected, TLS 1.3 suites are not configurable.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, exists := known[strings.ToUpper(name)]
+		if !exists {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// authenticateCertificate completes the TLS handshake of a client and, when
+// it presented a verified certificate whose common name is an enabled ACL
+// user, authenticates the client as that user
+func (server *Server) authenticateCertificate(c *client, conn *tls.Conn) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	name := state.PeerCertificates[0].Subject.CommonName
+	if user, exists := server.acl.User(name); exists && user.Enabled {
+		c.user, c.authenticated = name, true
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"tempDB/engine"
+	"tempDB/utils"
+)
+
+// handleTransaction handles MULTI, EXEC, DISCARD, WATCH and UNWATCH, and
+// queues every other command while the client is inside MULTI. It reports
+// whether the command was handled here.
+func (server *Server) handleTransaction(c *client, cmd []string) ([]byte, bool) {
+	switch cmd[0] {
+	case "MULTI":
+		if c.multi {
+			return []byte("-ERR MULTI calls can not be nested\r\n"), true
+		}
+		c.multi = true
+		return []byte("+OK\r\n"), true
+
+	case "EXEC":
+		if !c.multi {
+			return []byte("-ERR EXEC without MULTI\r\n"), true
+		}
+		defer c.resetTransaction()
+
+		if c.aborted {
+			return []byte("-EXECABORT Transaction discarded because of previous errors.\r\n"), true
+		}
+		response, err := server.Db.Exec(c.queued, c.watched, server.authorizer(c))
+		if err != nil {
+			return engine.ErrorReply(err), true
+		}
+		return response, true
+
+	case "DISCARD":
+		if !c.multi {
+			return []byte("-ERR DISCARD without MULTI\r\n"), true
+		}
+		c.resetTransaction()
+		return []byte("+OK\r\n"), true
+
+	case "WATCH":
+		if c.multi {
+			return []byte("-ERR WATCH inside MULTI is not allowed\r\n"), true
+		}
+		if c.watched == nil {
+			c.watched = make(map[string]uint64)
+		}
+		//a key watched twice keeps the version it had the first time
+		for key, version := range server.Db.Versions(cmd[1:]) {
+			if _, exists := c.watched[key]; !exists {
+				c.watched[key] = version
+			}
+		}
+		return []byte("+OK\r\n"), true
+
+	case "UNWATCH":
+		c.watched = nil
+		return []byte("+OK\r\n"), true
+	}
+
+	if c.multi {
+		c.queued = append(c.queued, utils.Request{Command: cmd[0], Params: cmd[1:]})
+		return []byte("+QUEUED\r\n"), true
+	}
+	return nil, false
+}
@@ -2,6 +2,8 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -13,54 +15,6 @@ type Request struct {
 	Params  []string
 }
 
-/*
-  - Validate Commands - basically check if the incoming commands
-    are as per the command structure supported
-*/
-func ValidCommand(cmd []string) bool {
-
-	switch cmd[0] {
-
-	case "PING":
-		if len(cmd) != 1 {
-			return false
-		}
-		return true
-	case "GET":
-		if len(cmd) != 2 {
-			return false
-		}
-		return true
-	case "SET":
-		if len(cmd) < 3 || len(cmd) > 5 {
-			return false
-		}
-		return true
-	case "DEL":
-		if len(cmd) != 2 {
-			return false
-		}
-		return true
-	case "FLUSHDB":
-		if len(cmd) != 1 {
-			return false
-		}
-		return true
-	case "EXPIRE":
-		if len(cmd) != 3 {
-			return false
-		}
-		return true
-	case "TTL":
-		if len(cmd) != 2 {
-			return false
-		}
-		return true
-	default:
-		return false
-	}
-}
-
 // func ParseCommands(input string) (Request, error) {
 
 // 	cmd := strings.Trim(input, "\r\n")
@@ -82,6 +36,15 @@ func ValidCommand(cmd []string) bool {
 // 	return Request{Command: args[0], Params: args[1:]}, nil
 // }
 
+// ErrProtocol is wrapped by the errors of malformed commands, after which
+// the rest of the stream cannot be parsed
+var ErrProtocol = errors.New("Protocol error")
+
+const (
+	maxArrayLen = 1024 * 1024       // elements of a command, as redis allows
+	maxBulkLen  = 512 * 1024 * 1024 // bytes of an argument, redis's proto-max-bulk-len
+)
+
 // ParseRESP reads a RESP array command from the connection and returns a slice of strings.
 func ParseRESP(r *bufio.Reader) ([]string, error) {
 	//remove delimeter and read command Length
@@ -92,29 +55,30 @@ func ParseRESP(r *bufio.Reader) ([]string, error) {
 
 	//check resp Format
 	if len(line) == 0 || line[0] != '*' {
-		return nil, fmt.Errorf("expected array")
+		return nil, fmt.Errorf("%w: expected array", ErrProtocol)
 	}
 	numElements, err := strconv.Atoi(strings.TrimSpace(line[1:]))
-	if err != nil {
-		return nil, fmt.Errorf("invalid array length")
+	if err != nil || numElements < 0 || numElements > maxArrayLen {
+		return nil, fmt.Errorf("%w: invalid array length", ErrProtocol)
 	}
 
-	//read the rest of the commands
-	result := make([]string, 0, numElements) //len, cap
+	//read the rest of the commands, growing the slice as they arrive so a
+	//large length alone allocates nothing
+	result := make([]string, 0, min(numElements, 1024)) //len, cap
 	for i := 0; i < numElements; i++ {
 		bulkHeader, err := r.ReadString('\n')
 		if err != nil {
 			return nil, err
 		}
 		if len(bulkHeader) == 0 || bulkHeader[0] != '$' {
-			return nil, fmt.Errorf("expected bulk string")
+			return nil, fmt.Errorf("%w: expected bulk string", ErrProtocol)
 		}
 		bulkLen, err := strconv.Atoi(strings.TrimSpace(bulkHeader[1:]))
-		if err != nil {
-			return nil, fmt.Errorf("invalid bulk string length")
+		if err != nil || bulkLen < 0 || bulkLen > maxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk string length", ErrProtocol)
 		}
-		bulkData := make([]byte, bulkLen+2) // +2 for \r\n
-		if _, err := io.ReadFull(r, bulkData); err != nil {
+		bulkData, err := readBulk(r, bulkLen+2) // +2 for \r\n
+		if err != nil {
 			return nil, err
 		}
 		result = append(result, string(bulkData[:bulkLen]))
@@ -122,3 +86,112 @@ func ParseRESP(r *bufio.Reader) ([]string, error) {
 
 	return result, nil
 }
+
+// readBulk reads n bytes. Large reads grow their buffer with the data
+// received, so a length announced alone allocates little.
+func readBulk(r *bufio.Reader, n int) ([]byte, error) {
+	if n <= 64*1024 {
+		data := make([]byte, n)
+		_, err := io.ReadFull(r, data)
+		return data, err
+	}
+
+	var data bytes.Buffer
+	if _, err := io.CopyN(&data, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// GlobMatch reports whether s matches the glob pattern, using the same rules
+// as redis: '*' matches any sequence, '?' any single character, '[...]' a
+// character class (with '^' negation and 'a-z' ranges) and '\' escapes.
+func GlobMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			//collapse consecutive stars, then try every possible split
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if GlobMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			matched, rest, ok := matchClass(pattern[1:], s[0])
+			if !ok || !matched {
+				return false
+			}
+			s = s[1:]
+			pattern = rest
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the character class at the start of pattern
+// (just after the '['), returning the remaining pattern after the closing ']'
+func matchClass(pattern string, c byte) (matched bool, rest string, ok bool) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == ']':
+			return matched != negate, pattern[i+1:], true
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			if pattern[i] == c {
+				matched = true
+			}
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			i += 2
+		default:
+			if pattern[i] == c {
+				matched = true
+			}
+		}
+	}
+
+	//unterminated class
+	return false, "", false
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRESP(t *testing.T) {
+	large := strings.Repeat("x", 100*1024)
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{"command", "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", []string{"GET", "k"}, nil},
+		{"empty array", "*0\r\n", []string{}, nil},
+		{"large argument", "*1\r\n$102400\r\n" + large + "\r\n", []string{large}, nil},
+		{"not an array", "GET k\r\n", nil, ErrProtocol},
+		{"negative array length", "*-1\r\n", nil, ErrProtocol},
+		{"array too long", "*9999999999999999\r\n", nil, ErrProtocol},
+		{"array over the limit", "*1048577\r\n", nil, ErrProtocol},
+		{"not a bulk string", "*1\r\n:1\r\n", nil, ErrProtocol},
+		{"bulk string over the limit", "*1\r\n$2000000000\r\n", nil, ErrProtocol},
+		{"bulk string cut short", "*1\r\n$3\r\nGE", nil, io.ErrUnexpectedEOF},
+		{"large bulk string cut short", "*1\r\n$102400\r\nxx", nil, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRESP(bufio.NewReader(strings.NewReader(tt.input)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseRESP error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) && tt.wantErr == nil {
+				t.Errorf("ParseRESP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}